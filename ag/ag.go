@@ -1,6 +1,7 @@
 // Ag searches open Acme windows for a regular expression, printing
 // results in the manner of grep so that they are B3-clickable inside
-// of Acme.
+// of Acme. With -json, it instead prints a JSON record for each match
+// for use by other programs.
 package main 
 
 import (
@@ -11,6 +12,7 @@ import (
 	"os"
 
 	"github.com/google/codesearch/regexp"
+	"github.com/mariusae/tools/internal/grep"
 
 	"9fans.net/go/acme"
 )
@@ -26,7 +28,7 @@ func usage() {
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("")
-	var g regexp.Grep
+	var g grep.Grep
 	g.AddFlags()
 	g.Stdout = os.Stdout
 	g.Stderr = os.Stderr
@@ -63,4 +65,7 @@ func main() {
 		}
 		g.Reader(bytes.NewReader(b), info.Name)
 	}
+	if g.JSON {
+		grep.WriteSummary(os.Stdout, g.Stats)
+	}
 }
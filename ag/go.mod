@@ -5,4 +5,7 @@ go 1.15
 require (
 	9fans.net/go v0.0.2
 	github.com/google/codesearch v1.2.0
+	github.com/mariusae/tools/internal v0.0.0
 )
+
+replace github.com/mariusae/tools/internal => ../internal
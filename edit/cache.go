@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/mariusae/tools/internal/walker"
+)
+
+// A fileCache is the on-disk list of the files below a search root,
+// with whether each is text. A directory is read again only when its
+// modification time changes, which it does when entries are added,
+// removed, or renamed; a file is checked for text again only when
+// its size or modification time has changed.
+type fileCache struct {
+	root  string // as given
+	abs   string // absolute root, recorded in the cache file
+	name  string // of the cache file
+	top   *cacheDir
+	dirty bool
+}
+
+// A cacheDir is the cached listing of a directory.
+type cacheDir struct {
+	Mtime int64                 // zero if not yet read
+	Files map[string]cachedFile // by name
+	Dirs  map[string]*cacheDir  // by name
+}
+
+// A cachedFile is the cached state of a file.
+type cachedFile struct {
+	Size  int64
+	Mtime int64
+	Text  bool
+}
+
+// cacheHome returns the directory holding the caches. Each is named
+// by a hash of its absolute root, and holds the root followed by the
+// listing of the top directory.
+func cacheHome() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "edit"), nil
+}
+
+// loadCache returns the cache for root, which is empty if there is
+// none or it cannot be read.
+func loadCache(root string) *fileCache {
+	c := &fileCache{root: root}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return c
+	}
+	dir, err := cacheHome()
+	if err != nil {
+		return c
+	}
+	sum := sha1.Sum([]byte(abs))
+	c.abs = abs
+	c.name = filepath.Join(dir, hex.EncodeToString(sum[:]))
+	f, err := os.Open(c.name)
+	if err != nil {
+		return c
+	}
+	defer f.Close()
+	dec := gob.NewDecoder(f)
+	var top cacheDir
+	if dec.Decode(&abs) == nil && abs == c.abs && dec.Decode(&top) == nil {
+		c.top = &top
+	}
+	return c
+}
+
+// cachedRoots returns the absolute roots of the caches there are.
+func cachedRoots() ([]string, error) {
+	dir, err := cacheHome()
+	if err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "[0-9a-f]*"))
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		var root string
+		if gob.NewDecoder(f).Decode(&root) == nil {
+			roots = append(roots, root)
+		}
+		f.Close()
+	}
+	return roots, nil
+}
+
+// save writes the cache if it has changed.
+func (c *fileCache) save() error {
+	if !c.dirty || c.name == "" || c.top == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.name), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.name), ".cache")
+	if err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(f)
+	err = enc.Encode(c.abs)
+	if err == nil {
+		err = enc.Encode(c.top)
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// walk calls fn for each text file and directory below the root, with
+// its path and its slash-separated path relative to the root, bringing
+// the cache up to date as it goes. For a directory, fn reports whether
+// to walk below it; the cached listings below one it does not are kept
+// as they are. With force, every directory walked is read again and
+// every file checked again.
+func (c *fileCache) walk(force bool, fn func(path, rel string, isDir bool) bool) {
+	c.top = c.visit(".", c.top, walker.NewIgnorer(c.root), force, fn)
+}
+
+// visit walks the directory rel, whose listing is d and whose ignore
+// files are those of ig.
+func (c *fileCache) visit(rel string, d *cacheDir, ig *walker.Ignorer, force bool, fn func(path, rel string, isDir bool) bool) *cacheDir {
+	old := d
+	d = c.refresh(rel, d, ig, force)
+	if d == nil {
+		return nil
+	}
+	for name, f := range d.Files {
+		r := path.Join(rel, name)
+		p := filepath.Join(c.root, filepath.FromSlash(r))
+		// A file changed in place does not change its directory.
+		if d == old {
+			if info, err := os.Stat(p); err == nil && (info.Size() != f.Size || info.ModTime().UnixNano() != f.Mtime) {
+				f = cachedFile{info.Size(), info.ModTime().UnixNano(), contentOk(p)}
+				d.Files[name] = f
+				c.dirty = true
+			}
+		}
+		if f.Text {
+			fn(p, r, false)
+		}
+	}
+	for name, sub := range d.Dirs {
+		r := path.Join(rel, name)
+		p := filepath.Join(c.root, filepath.FromSlash(r))
+		if !fn(p, r, true) {
+			continue
+		}
+		if sub = c.visit(r, sub, ig.Enter(p), force, fn); sub != nil {
+			d.Dirs[name] = sub
+		} else {
+			delete(d.Dirs, name)
+			c.dirty = true
+		}
+	}
+	return d
+}
+
+// refresh returns the listing of the directory rel, reading it again
+// if it has changed since the listing d was made. It returns nil if
+// the directory no longer exists.
+func (c *fileCache) refresh(rel string, d *cacheDir, ig *walker.Ignorer, force bool) *cacheDir {
+	dir := filepath.Join(c.root, filepath.FromSlash(rel))
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+	mtime := info.ModTime().UnixNano()
+	if d != nil && !force && d.Mtime == mtime {
+		return d
+	}
+	if d == nil {
+		d = new(cacheDir)
+	}
+	next := &cacheDir{
+		Mtime: mtime,
+		Files: make(map[string]cachedFile),
+		Dirs:  make(map[string]*cacheDir),
+	}
+	// The walker applies the ignore files in effect in dir, and
+	// skips version control directories.
+	w := walker.New(dir)
+	w.Ignore = true
+	w.Ignorer = ig
+	w.MaxDepth = 1
+	for w.Next() {
+		if w.Depth() == 0 {
+			continue
+		}
+		name, info := filepath.Base(w.Path()), w.Info()
+		if info.IsDir() {
+			sub := d.Dirs[name]
+			if sub == nil {
+				sub = new(cacheDir)
+			}
+			next.Dirs[name] = sub
+			continue
+		}
+		f := cachedFile{Size: info.Size(), Mtime: info.ModTime().UnixNano()}
+		if old, ok := d.Files[name]; ok && !force && old.Size == f.Size && old.Mtime == f.Mtime {
+			f.Text = old.Text
+		} else {
+			f.Text = contentOk(w.Path())
+		}
+		next.Files[name] = f
+	}
+	c.dirty = true
+	return next
+}
@@ -1,3 +1,7 @@
 module github.com/mariusae/tools/edit
 
 go 1.12
+
+require github.com/mariusae/tools/internal v0.0.0
+
+replace github.com/mariusae/tools/internal => ../internal
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The history records how often and how recently each file was
+// opened, so that frequently and recently used files rank higher.
+// It is kept as lines of "count unixtime path" in historyFile.
+const (
+	maxHistory   = 2000                // entries kept, by frecency
+	maxAge       = 90 * 24 * time.Hour // age at which -prune drops an entry
+	staleLock    = 10 * time.Second    // age at which a lock is assumed abandoned
+	lockWait     = 5 * time.Second     // how long to wait for the lock
+	maxFrecBonus = 3 * scoreMatch      // largest score bonus from history
+)
+
+// A histEntry is the history of one file.
+type histEntry struct {
+	path  string
+	count int
+	last  time.Time
+}
+
+// frecency combines the frequency and recency of use of e.
+func (e histEntry) frecency(now time.Time) float64 {
+	age := now.Sub(e.last)
+	w := 0.25
+	switch {
+	case age < time.Hour:
+		w = 4
+	case age < 24*time.Hour:
+		w = 2
+	case age < 7*24*time.Hour:
+		w = 1
+	case age < 30*24*time.Hour:
+		w = 0.5
+	}
+	return float64(e.count) * w
+}
+
+// bonus returns the score bonus for a file with history e.
+func (e histEntry) bonus(now time.Time) int {
+	b := int(float64(scoreMatch) * math.Log2(1+e.frecency(now)))
+	if b > maxFrecBonus {
+		b = maxFrecBonus
+	}
+	return b
+}
+
+// historyFile returns the name of the history file, in
+// $XDG_STATE_HOME/edit, or ~/.local/state/edit by default.
+func historyFile() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "edit", "history"), nil
+}
+
+// readHistory reads the history, which is empty if it does not
+// exist. Malformed lines are skipped.
+func readHistory() (map[string]histEntry, error) {
+	name, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	h := make(map[string]histEntry)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		fields := strings.SplitN(scan.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		count, err1 := strconv.Atoi(fields[0])
+		last, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		h[fields[2]] = histEntry{fields[2], count, time.Unix(last, 0)}
+	}
+	return h, scan.Err()
+}
+
+// updateHistory applies fn to the history and writes the result.
+// Concurrent updates are serialized by a lock file, and the history
+// is replaced atomically, so that readers need not lock it.
+func updateHistory(fn func(h map[string]histEntry)) error {
+	name, err := historyFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(name + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	h, err := readHistory()
+	if err != nil {
+		return err
+	}
+	fn(h)
+	entries := sortHistory(h, time.Now())
+	if len(entries) > maxHistory {
+		entries = entries[:maxHistory]
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), ".history")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d %d %s\n", e.count, e.last.Unix(), e.path)
+	}
+	err = w.Flush()
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// lockFile acquires the lock file name, returning a function that
+// releases it. A lock older than staleLock is taken to have been
+// abandoned by a process that died holding it.
+func lockFile(name string) (unlock func(), err error) {
+	deadline := time.Now().Add(lockWait)
+	for delay := time.Millisecond; ; delay *= 2 {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for " + name)
+		}
+		if delay > 100*time.Millisecond {
+			delay = 100 * time.Millisecond
+		}
+		time.Sleep(delay)
+	}
+}
+
+// recordHistory notes that the files paths were opened now.
+func recordHistory(paths []string) error {
+	now := time.Now()
+	return updateHistory(func(h map[string]histEntry) {
+		for _, path := range paths {
+			e := h[path]
+			e.path = path
+			e.count++
+			e.last = now
+			h[path] = e
+		}
+	})
+}
+
+// pruneHistory removes the entries for files that no longer exist
+// or that have not been opened within maxAge.
+func pruneHistory() error {
+	now := time.Now()
+	return updateHistory(func(h map[string]histEntry) {
+		for path, e := range h {
+			if _, err := os.Stat(path); err != nil || now.Sub(e.last) > maxAge {
+				delete(h, path)
+			}
+		}
+	})
+}
+
+// sortHistory returns the entries of h, most frecent first.
+func sortHistory(h map[string]histEntry, now time.Time) []histEntry {
+	entries := make([]histEntry, 0, len(h))
+	for _, e := range h {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		fi, fj := entries[i].frecency(now), entries[j].frecency(now)
+		if fi != fj {
+			return fi > fj
+		}
+		return entries[i].path < entries[j].path
+	})
+	return entries
+}
+
+// printHistory lists the history, most frecent first.
+func printHistory(w io.Writer, h map[string]histEntry) {
+	now := time.Now()
+	for _, e := range sortHistory(h, now) {
+		fmt.Fprintf(w, "%.2f\t%d\t%s\t%s\n", e.frecency(now), e.count, e.last.Format("2006-01-02 15:04"), e.path)
+	}
+}
@@ -5,155 +5,285 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
-	"os/exec"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"path/filepath"
+
+	"github.com/mariusae/tools/internal/content"
 )
 
 func main() {
 	log.SetPrefix("")
 	log.SetFlags(0)
-	listFlag := flag.Bool("n", false, "list results")
+	listFlag := flag.Bool("n", false, "list results, ranked, with their scores")
+	allFlag := flag.Bool("a", false, "open all results")
+	oneFlag := flag.Bool("1", false, "open, or with -n list, only the best result")
+	dirsFlag := flag.Bool("d", false, "edit directories, not files")
+	pickFlag := flag.Bool("i", false, "choose among several results interactively")
+	historyFlag := flag.Bool("history", false, "list the history of opened files, most frecent first")
+	pruneFlag := flag.Bool("prune", false, "with -history, remove files that no longer exist or have not been opened recently")
+	refreshFlag := flag.Bool("refresh", false, "rebuild the file list caches of the search paths, or with no query, all of them")
+	openFlag := flag.String("open", "", "open files with `opener`: plumb, editor ($VISUAL or $EDITOR), or print (default $EDITOPEN, or plumb)")
 	flag.Usage = func() {
 		io.WriteString(os.Stderr, `usage:
 	edit query
 	edit query paths...
+	edit -history [-prune]
+	edit -refresh
+
+A query is matched against file names, or if it contains slashes,
+its components against the path components below the search path,
+the last against the file name. As in go command patterns, a
+component "..." matches any number of components, so that
+.../server/main matches cmd/server/main.go, and directories that
+cannot hold a match are not searched. With -d, directories are
+matched instead of files, and opening one in acme shows its listing.
+A query may end in an address, as in foo.go:123, foo.go:/regexp/, or
+foo:#45, which is passed on to the plumber.
+
+Paths other than explicit ones, beginning with / or ., are looked up
+below each $EDITPATH directory and each project root, a directory
+containing go.mod or .git, from the current directory upward. They
+are also taken as Go import paths, found in the modules of the
+project roots, following replace directives and the module cache,
+or in the standard library, as in edit server.go net/http.
+
+Results are ranked by how well they match, and by how often and how
+recently they have been opened.
+
+With -i, several results are shown in a picker on the terminal, where
+typing narrows the query, the arrow keys or ^N and ^P move the
+selection, return opens it, and escape cancels. In acme, or on a dumb
+terminal, the results are listed as without -i.
+
+The files below each search path are listed in a cache, which is
+brought up to date as directories change. With -refresh and no query,
+every cache is rebuilt.
 `)
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
 	flag.Parse()
+	if *historyFlag {
+		if *pruneFlag {
+			if err := pruneHistory(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		h, err := readHistory()
+		if err != nil {
+			log.Fatal(err)
+		}
+		printHistory(os.Stdout, h)
+		return
+	}
+	if *refreshFlag && flag.NArg() == 0 {
+		roots, err := cachedRoots()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, root := range roots {
+			c := loadCache(root)
+			// The cache of a root that is gone is of no use.
+			if _, err := os.Stat(root); os.IsNotExist(err) {
+				os.Remove(c.name)
+				continue
+			}
+			c.walk(true, func(path, rel string, isDir bool) bool { return true })
+			if err := c.save(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
 	if flag.NArg() < 1 {
 		flag.Usage()
 	}
-	query := flag.Arg(0)
+	name := *openFlag
+	if name == "" {
+		name = os.Getenv("EDITOPEN")
+	}
+	if name == "" {
+		name = "plumb"
+	}
+	opener, err := newOpener(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	query, addr := splitAddr(flag.Arg(0))
 	var paths []string
 	if flag.NArg() == 1 {
 		paths = []string{"."}
 	} else {
+		roots := projectRoots()
 		for i := 1; i < flag.NArg(); i++ {
 			arg := flag.Arg(i)
 			// If it's an explicit path then use it directly, otherwise
-			// find a matching EDITPATH directory.
+			// find the directories it names.
 			if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
 				paths = append(paths, arg)
 				continue
 			}
-			for _, prefix := range filepath.SplitList(os.Getenv("EDITPATH")) {
-				dirpath := filepath.Join(prefix, arg)
-				info, err := os.Stat(dirpath)
-				if err == nil && info.IsDir() {
-					paths = append(paths, dirpath)
-				}
-			}
+			paths = append(paths, searchDirs(arg, roots)...)
 		}
 	}
 	if len(paths) == 0 {
 		log.Fatal("no search paths found")
 	}
-	matches := make(map[string]bool)
-	for _, root := range paths {
-		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if err != nil || info == nil {
-				return nil
-			}
-			if info.IsDir() {
-				return nil
-			}
-			relpath, err := filepath.Rel(root, path)
-			if err != nil {
-				log.Fatal(err)
+	bonus := func(string) int { return 0 }
+	if h, err := readHistory(); err != nil {
+		log.Printf("history: %v", err)
+	} else if len(h) > 0 {
+		now := time.Now()
+		bonus = func(path string) int {
+			if abs, err := filepath.Abs(path); err == nil {
+				if e, ok := h[abs]; ok {
+					return e.bonus(now)
+				}
 			}
-
-			dir, file := filepath.Split(relpath)
-			dir = strings.TrimSuffix(dir, "/")
-			if !matchf(query, file) || !contentOk(path) {
-				return nil
+			return 0
+		}
+	}
+	picking := *pickFlag && !*listFlag && !*allFlag && !*oneFlag && canPick()
+	var files []candidate
+	matches := make(map[string]int)
+	// A file may be reached from more than one search path; seen maps
+	// each file's absolute path to the path it was first found by.
+	seen := make(map[string]string)
+	add := func(path, rel string) {
+		if abs, err := filepath.Abs(path); err == nil {
+			if first, ok := seen[abs]; ok && first != path {
+				return
 			}
-
-			if matches[path] {
-				return nil
+			seen[abs] = path
+		}
+		if picking {
+			files = append(files, candidate{path, rel})
+		}
+		score, ok := scorePath(query, rel)
+		if !ok {
+			return
+		}
+		if old, ok := matches[path]; !ok || score > old {
+			matches[path] = score
+		}
+	}
+	for _, root := range paths {
+		c := loadCache(root)
+		c.walk(*refreshFlag, func(path, rel string, isDir bool) bool {
+			if isDir == *dirsFlag {
+				add(path, rel)
 			}
-			matches[path] = true
-			return nil
+			// The picker may change the query, so it needs every
+			// file.
+			return picking || dirOk(query, rel)
 		})
+		if err := c.save(); err != nil {
+			log.Printf("cache: %v", err)
+		}
 	}
 
 	if len(matches) == 0 {
 		os.Exit(1)
 	}
-	if len(matches) > 1 || *listFlag {
-		sorted := make([]string, 0, len(matches))
-		for filepath := range matches {
-			sorted = append(sorted, filepath)
-		}
-		sort.Strings(sorted)
-		for _, filepath := range sorted {
-			fmt.Println(filepath)
+	for path := range matches {
+		matches[path] += bonus(path)
+	}
+	ranked := rank(matches)
+	if *oneFlag {
+		ranked = ranked[:1]
+	}
+	if *listFlag {
+		for _, path := range ranked {
+			fmt.Printf("%d\t%s\n", matches[path], withAddr(path, addr))
 		}
 		os.Exit(0)
 	}
-
-	for file := range matches {
-		file, err := filepath.Abs(file)
+	if picking && len(ranked) > 1 {
+		path, a, err := pick(files, flag.Arg(0), ranked, bonus)
 		if err != nil {
 			log.Fatal(err)
 		}
-		plumb(file, "")
+		if path == "" {
+			os.Exit(1)
+		}
+		ranked, addr = []string{path}, a
+	} else if !*allFlag {
+		// Open the best match if it is clearly ahead of the rest;
+		// otherwise list them all, best first.
+		if len(ranked) > 1 && matches[ranked[0]]-matches[ranked[1]] < clearLead {
+			for _, path := range ranked {
+				fmt.Println(withAddr(path, addr))
+			}
+			os.Exit(0)
+		}
+		ranked = ranked[:1]
 	}
-}
-
-func matchf(query, s string) bool {
-	for _, r := range query {
-		i := strings.IndexRune(s, r)
-		if i < 0 {
-			return false
+	var opened []string
+	for _, path := range ranked {
+		file, err := filepath.Abs(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := opener.open(file, addr); err != nil {
+			log.Fatal(err)
 		}
-		s = s[i:]
+		opened = append(opened, file)
 	}
-	return true
-}
-
-func contentOk(path string) bool {
-	f, err := os.Open(path)
-	if err != nil {
-		return false
+	if name != "print" {
+		if err := recordHistory(opened); err != nil {
+			log.Printf("history: %v", err)
+		}
 	}
-	defer f.Close()
-
-	var data [512]byte
+}
 
-	n, err := io.ReadFull(f, data[:])
-	if n == 0 && (err == io.ErrUnexpectedEOF || err == io.EOF) {
-		return true
-	} else if n <= 0 {
-		return false
+// rank returns the paths in matches, best scoring first, and then
+// shortest.
+func rank(matches map[string]int) []string {
+	ranked := make([]string, 0, len(matches))
+	for path := range matches {
+		ranked = append(ranked, path)
 	}
-
-	// This may be too strict -- we could also implement
-	// something similar to silver searcher's heuristic.
-	tpe := http.DetectContentType(data[:n])
-
-	/*
-		if !strings.HasPrefix(tpe, "text/") {
-			log.Printf("Bad content type \"%s\" for %s", tpe, path)
+	sort.Slice(ranked, func(i, j int) bool {
+		si, sj := matches[ranked[i]], matches[ranked[j]]
+		if si != sj {
+			return si > sj
 		}
-	*/
+		if len(ranked[i]) != len(ranked[j]) {
+			return len(ranked[i]) < len(ranked[j])
+		}
+		return ranked[i] < ranked[j]
+	})
+	return ranked
+}
 
-	return strings.HasPrefix(tpe, "text/")
+func contentOk(path string) bool {
+	return content.IsText(path)
 }
 
-func plumb(path, line string) {
-	if line != "" {
-		path += ":" + line
+// addrRE matches a query ending in an acme address: a line number,
+// a regular expression, or a character offset. A column number and
+// trailing colon, as in compiler messages, are allowed and dropped.
+var addrRE = regexp.MustCompile(`^(.+?):([0-9]+|/.*|#[0-9]+)(:[0-9]*)?:?$`)
+
+// splitAddr splits query into the query proper and its address,
+// if it has one.
+func splitAddr(query string) (string, string) {
+	m := addrRE.FindStringSubmatch(query)
+	if m == nil {
+		return query, ""
 	}
+	return m[1], m[2]
+}
 
-	out, err := exec.Command("plumb", "-d", "edit", path).CombinedOutput()
-	if err != nil {
-		log.Fatalf("plumb: %v\n%s", err, out)
+// withAddr returns path with the address addr, if any, appended.
+func withAddr(path, addr string) string {
+	if addr == "" {
+		return path
 	}
+	return path + ":" + addr
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Scoring for fuzzy matches. Each matched character scores
+// scoreMatch, plus bonuses for where it falls; unmatched characters
+// between matches cost scoreGap each.
+const (
+	scoreMatch       = 16
+	scoreGap         = 1
+	bonusConsecutive = 8  // follows the previous match directly
+	bonusBoundary    = 8  // starts a word, as after _ or in camelCase
+	bonusComponent   = 12 // starts a path component
+	bonusPrefix      = 24 // the query is a prefix of the base name
+	bonusExact       = 48 // the query is the base name, or it without extension
+)
+
+// clearLead is how far the best match must be ahead of the next
+// for it to be opened without listing the others.
+const clearLead = scoreMatch
+
+// score scores the relative slash-separated path rel as a match for
+// query, whose characters must appear in rel in order. Matching is
+// case-insensitive unless query contains an upper-case letter. It
+// reports false if rel does not match.
+func score(query, rel string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	fold := strings.ToLower(query) == query
+	q := []rune(query)
+	r := []rune(rel)
+	if fold {
+		for i := range r {
+			r[i] = unicode.ToLower(r[i])
+		}
+	}
+	orig := []rune(rel)
+
+	// best[j] is the best score for the query so far with its last
+	// character matched at r[j], or -1 if there is none.
+	const none = -1 << 30
+	best := make([]int, len(r))
+	next := make([]int, len(r))
+	for i := range q {
+		// run is the best of prev[k] + k*scoreGap for k < j-1.
+		run := none
+		for j := range r {
+			if i > 0 && j >= 2 && best[j-2] != none && best[j-2]+(j-2)*scoreGap > run {
+				run = best[j-2] + (j-2)*scoreGap
+			}
+			next[j] = none
+			if r[j] != q[i] {
+				continue
+			}
+			s := scoreMatch + charBonus(orig, j)
+			switch {
+			case i == 0:
+				next[j] = s - j*scoreGap/4
+			default:
+				p := none
+				if j >= 1 && best[j-1] != none {
+					p = best[j-1] + bonusConsecutive
+				}
+				if run != none && run-(j-1)*scoreGap > p {
+					p = run - (j-1)*scoreGap
+				}
+				if p != none {
+					next[j] = p + s
+				}
+			}
+		}
+		best, next = next, best
+	}
+
+	total := none
+	for _, s := range best {
+		if s > total {
+			total = s
+		}
+	}
+	if total == none {
+		return 0, false
+	}
+	name := path.Base(rel)
+	if fold {
+		name = strings.ToLower(name)
+	}
+	switch {
+	case name == query, strings.TrimSuffix(name, path.Ext(name)) == query:
+		total += bonusExact
+	case strings.HasPrefix(name, query):
+		total += bonusPrefix
+	}
+	// Prefer shorter paths.
+	return total - len(r)/4, true
+}
+
+// scorePath scores the relative slash-separated path rel as a match
+// for query. A query containing slashes is matched component by
+// component against the path components, its last against the last;
+// the score is the sum. As in go command patterns, a query component
+// that is just "..." matches any number of path components, including
+// none, at no cost, so that .../server/main matches
+// cmd/server/main.go. Otherwise query is matched against the base
+// name, and the rest of the path counts only against the score.
+func scorePath(query, rel string) (int, bool) {
+	if !strings.Contains(query, "/") {
+		dir, name := path.Split(rel)
+		s, ok := score(stripDots(query), name)
+		return s - utf8.RuneCountInString(dir)/4, ok
+	}
+	return scoreComponents(strings.Split(query, "/"), strings.Split(rel, "/"))
+}
+
+// scoreComponents scores the path components ps as a match for the
+// query components qs.
+func scoreComponents(qs, ps []string) (int, bool) {
+	// best[i][j] is the best score for qs[i:] against ps[j:], or
+	// none if they do not match.
+	const none = -1 << 30
+	best := make([][]int, len(qs)+1)
+	for i := range best {
+		best[i] = make([]int, len(ps)+1)
+	}
+	for i := len(qs); i >= 0; i-- {
+		for j := len(ps); j >= 0; j-- {
+			b := none
+			switch {
+			case i == len(qs):
+				if j == len(ps) {
+					b = 0
+				}
+			case qs[i] == "...":
+				for k := j; k <= len(ps); k++ {
+					if best[i+1][k] > b {
+						b = best[i+1][k]
+					}
+				}
+			case j < len(ps) && best[i+1][j+1] != none:
+				if s, ok := score(stripDots(qs[i]), ps[j]); ok {
+					b = s + best[i+1][j+1]
+				}
+			}
+			best[i][j] = b
+		}
+	}
+	if best[0][0] == none {
+		return 0, false
+	}
+	return best[0][0], true
+}
+
+// dirOk reports whether the directory at the relative slash-separated
+// path rel may hold a match for query, so that directories that cannot
+// are not walked. Only a query containing slashes rules any out.
+func dirOk(query, rel string) bool {
+	if !strings.Contains(query, "/") || rel == "." {
+		return true
+	}
+	qs := strings.Split(query, "/")
+	// at[i] is whether qs[:i] can match the components so far.
+	at := make([]bool, len(qs)+1)
+	at[0] = true
+	dots := func() {
+		for i := range qs {
+			if at[i] && qs[i] == "..." {
+				at[i+1] = true
+			}
+		}
+	}
+	dots()
+	for _, p := range strings.Split(rel, "/") {
+		next := make([]bool, len(qs)+1)
+		for i, q := range qs {
+			switch {
+			case !at[i]:
+			case q == "...":
+				next[i] = true
+			default:
+				if _, ok := score(stripDots(q), p); ok {
+					next[i+1] = true
+				}
+			}
+		}
+		at = next
+		dots()
+	}
+	// The files below need at least one more component, which only
+	// a query component yet to be matched can take.
+	for i := range qs {
+		if at[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// stripDots removes the "..." wildcards from a query matched as a
+// subsequence, which lets any string through anyway.
+func stripDots(query string) string {
+	return strings.Replace(query, "...", "", -1)
+}
+
+// charBonus returns the bonus for a match at r[j], depending on
+// whether it begins a path component or a word.
+func charBonus(r []rune, j int) int {
+	if j == 0 {
+		return bonusComponent
+	}
+	prev, c := r[j-1], r[j]
+	switch {
+	case prev == '/':
+		return bonusComponent
+	case prev == '_' || prev == '-' || prev == '.' || prev == ' ':
+		return bonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(c):
+		return bonusBoundary
+	case !unicode.IsDigit(prev) && unicode.IsDigit(c):
+		return bonusBoundary
+	}
+	return 0
+}
@@ -4,14 +4,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/mariusae/tools/internal/content"
 )
 
 // last element
@@ -147,32 +147,7 @@ func plumb(path, line string) {
 }
 
 func contentOk(path string) bool {
-	f, err := os.Open(path)
-	if err != nil {
-		return false
-	}
-	defer f.Close()
-
-	var data [512]byte
-
-	n, err := io.ReadFull(f, data[:])
-	if n == 0 && (err == io.ErrUnexpectedEOF || err == io.EOF) {
-		return true
-	} else if n <= 0 {
-		return false
-	}
-
-	// This may be too strict -- we could also implement
-	// something similar to silver searcher's heuristic.
-	tpe := http.DetectContentType(data[:n])
-
-	/*
-		if !strings.HasPrefix(tpe, "text/") {
-			log.Printf("Bad content type \"%s\" for %s", tpe, path)
-		}
-	*/
-
-	return strings.HasPrefix(tpe, "text/")
+	return content.IsText(path)
 }
 
 // from cmd/go/main.go
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// An opener opens a file for editing at an acme address: a line
+// number, a /regexp/, or a #character offset. The address may be
+// empty.
+type opener interface {
+	open(path, addr string) error
+}
+
+// newOpener returns the opener called name: plumb, editor, or print.
+func newOpener(name string) (opener, error) {
+	switch name {
+	case "plumb":
+		return plumber{}, nil
+	case "editor":
+		return newEditor()
+	case "print":
+		return printer{os.Stdout}, nil
+	}
+	return nil, fmt.Errorf("unknown opener %q: want plumb, editor, or print", name)
+}
+
+// A plumber sends files to the plan9port plumber's edit port.
+type plumber struct{}
+
+func (plumber) open(path, addr string) error {
+	out, err := exec.Command("plumb", "-d", "edit", withAddr(path, addr)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("plumb: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// A printer prints file names with their addresses.
+type printer struct{ w io.Writer }
+
+func (p printer) open(path, addr string) error {
+	_, err := fmt.Fprintln(p.w, withAddr(path, addr))
+	return err
+}
+
+// An editor runs $VISUAL or $EDITOR, passing the address in the
+// manner the editor expects. Addresses an editor cannot take are
+// dropped.
+type editor struct {
+	cmd []string
+}
+
+func newEditor() (*editor, error) {
+	cmd := os.Getenv("VISUAL")
+	if cmd == "" {
+		cmd = os.Getenv("EDITOR")
+	}
+	if cmd == "" {
+		return nil, fmt.Errorf("neither $VISUAL nor $EDITOR is set")
+	}
+	return &editor{strings.Fields(cmd)}, nil
+}
+
+func (e *editor) open(path, addr string) error {
+	cmd := exec.Command(e.cmd[0], append(e.cmd[1:], e.args(path, addr)...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// args returns the arguments opening path at addr.
+func (e *editor) args(path, addr string) []string {
+	line := ""
+	if _, err := strconv.Atoi(addr); err == nil {
+		line = addr
+	}
+	switch name := filepath.Base(e.cmd[0]); name {
+	case "vi", "vim", "nvim", "gvim", "mvim", "view":
+		switch {
+		case line != "":
+			return []string{"+" + line, path}
+		case strings.HasPrefix(addr, "/"):
+			return []string{"+" + strings.TrimSuffix(addr, "/") + "/", path}
+		case strings.HasPrefix(addr, "#") && name != "vi" && name != "view":
+			// :goto counts bytes from 1.
+			n, err := strconv.Atoi(addr[1:])
+			if err == nil {
+				return []string{"+goto " + strconv.Itoa(n+1), path}
+			}
+		}
+	case "emacs", "emacsclient", "nano", "micro", "mg", "kak":
+		if line != "" {
+			return []string{"+" + line, path}
+		}
+	case "code", "code-insiders", "codium", "cursor":
+		if line != "" {
+			return []string{"-g", path + ":" + line}
+		}
+	case "subl", "zed", "hx", "helix":
+		if line != "" {
+			return []string{path + ":" + line}
+		}
+	}
+	return []string{path}
+}
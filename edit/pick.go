@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxPick is the most candidates the picker shows at once.
+const maxPick = 10
+
+// A candidate is a file that may be picked, with its path relative
+// to its search path.
+type candidate struct {
+	path, rel string
+}
+
+// A picker lets the user choose among the candidates on a terminal,
+// narrowing the query as they type. It needs only ANSI escapes and
+// stty(1).
+type picker struct {
+	tty   *os.File
+	files []candidate
+	bonus func(path string) int
+
+	query  []rune
+	ranked []string
+	sel    int // index in ranked of the selection
+	top    int // index in ranked of the first candidate shown
+	rows   int // candidates shown at most
+	cols   int
+}
+
+// canPick reports whether the picker can run: there is a terminal,
+// and it is not acme's, whose windows do not take escapes.
+func canPick() bool {
+	if os.Getenv("winid") != "" {
+		return false
+	}
+	switch os.Getenv("TERM") {
+	case "", "dumb":
+		return false
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	tty.Close()
+	return true
+}
+
+// pick runs the picker on the files, starting with query and its
+// ranked matches. It returns the chosen path and the address given
+// with the query, or an empty path if the user cancelled.
+func pick(files []candidate, query string, ranked []string, bonus func(string) int) (path, addr string, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", "", err
+	}
+	defer tty.Close()
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		return "", "", err
+	}
+	defer stty(tty, strings.TrimSpace(saved))
+
+	p := &picker{
+		tty:    tty,
+		files:  files,
+		bonus:  bonus,
+		query:  []rune(query),
+		ranked: ranked,
+		rows:   maxPick,
+		cols:   80,
+	}
+	if size, err := stty(tty, "size"); err == nil {
+		if f := strings.Fields(size); len(f) == 2 {
+			rows, err1 := strconv.Atoi(f[0])
+			cols, err2 := strconv.Atoi(f[1])
+			if err1 == nil && err2 == nil && rows > 1 && cols > 0 {
+				if rows-1 < p.rows {
+					p.rows = rows - 1
+				}
+				p.cols = cols
+			}
+		}
+	}
+	defer p.clear()
+	return p.run()
+}
+
+// stty runs stty(1) with args on tty, returning its output.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty: %v", err)
+	}
+	return string(out), nil
+}
+
+var errCancel = errors.New("cancelled")
+
+// run reads and applies keys until a file is chosen or the user
+// cancels.
+func (p *picker) run() (path, addr string, err error) {
+	buf := make([]byte, 64)
+	for {
+		p.draw()
+		n, err := p.tty.Read(buf)
+		if err != nil {
+			return "", "", err
+		}
+		path, err := p.keys(buf[:n])
+		if err == errCancel {
+			return "", "", nil
+		} else if err != nil {
+			return "", "", err
+		}
+		if path != "" {
+			_, addr := splitAddr(string(p.query))
+			return path, addr, nil
+		}
+	}
+}
+
+// keys applies the keys in b, returning the chosen path, if any.
+func (p *picker) keys(b []byte) (string, error) {
+	for len(b) > 0 {
+		c := b[0]
+		b = b[1:]
+		switch c {
+		case '\r', '\n':
+			if len(p.ranked) == 0 {
+				continue
+			}
+			return p.ranked[p.sel], nil
+		case 3, 7: // ^C, ^G
+			return "", errCancel
+		case 27: // ESC, alone or starting an arrow key
+			if len(b) == 0 {
+				return "", errCancel
+			}
+			if len(b) >= 2 && (b[0] == '[' || b[0] == 'O') {
+				switch b[1] {
+				case 'A':
+					p.move(-1)
+				case 'B':
+					p.move(+1)
+				}
+				b = b[2:]
+				continue
+			}
+		case 14: // ^N
+			p.move(+1)
+		case 16: // ^P
+			p.move(-1)
+		case 127, 8: // DEL, ^H
+			if len(p.query) > 0 {
+				p.query = p.query[:len(p.query)-1]
+				p.rank()
+			}
+		case 21: // ^U
+			p.query = p.query[:0]
+			p.rank()
+		case 23: // ^W
+			q := strings.TrimRight(string(p.query), "/._- ")
+			i := strings.LastIndexAny(q, "/._- ")
+			p.query = []rune(q[:i+1])
+			p.rank()
+		default:
+			if c < ' ' {
+				continue
+			}
+			b = append([]byte{c}, b...)
+			r, size := utf8.DecodeRune(b)
+			b = b[size:]
+			p.query = append(p.query, r)
+			p.rank()
+		}
+	}
+	return "", nil
+}
+
+// move moves the selection by delta, within the ranked candidates.
+func (p *picker) move(delta int) {
+	p.sel += delta
+	if p.sel >= len(p.ranked) {
+		p.sel = len(p.ranked) - 1
+	}
+	if p.sel < 0 {
+		p.sel = 0
+	}
+}
+
+// rank ranks the files against the current query.
+func (p *picker) rank() {
+	query, _ := splitAddr(string(p.query))
+	matches := make(map[string]int)
+	for _, f := range p.files {
+		s, ok := scorePath(query, f.rel)
+		if !ok {
+			continue
+		}
+		if old, ok := matches[f.path]; !ok || s > old {
+			matches[f.path] = s
+		}
+	}
+	for path := range matches {
+		matches[path] += p.bonus(path)
+	}
+	p.ranked = rank(matches)
+	p.sel, p.top = 0, 0
+}
+
+// draw draws the prompt and the candidates below it, leaving the
+// cursor at the end of the query.
+func (p *picker) draw() {
+	if p.sel < p.top {
+		p.top = p.sel
+	}
+	if p.sel >= p.top+p.rows {
+		p.top = p.sel - p.rows + 1
+	}
+	var b strings.Builder
+	prompt := fmt.Sprintf("%d/%d> ", len(p.ranked), len(p.files))
+	b.WriteString("\r\033[J")
+	b.WriteString(p.trim(prompt + string(p.query)))
+	lines := 0
+	for i := p.top; i < len(p.ranked) && i < p.top+p.rows; i++ {
+		b.WriteString("\r\n")
+		if i == p.sel {
+			b.WriteString("\033[7m" + p.trim(p.ranked[i]) + "\033[m")
+		} else {
+			b.WriteString(p.trim(p.ranked[i]))
+		}
+		lines++
+	}
+	if lines > 0 {
+		fmt.Fprintf(&b, "\033[%dA", lines)
+	}
+	col := utf8.RuneCountInString(prompt) + len(p.query)
+	if col >= p.cols {
+		col = p.cols - 1
+	}
+	fmt.Fprintf(&b, "\r\033[%dC", col)
+	p.tty.WriteString(b.String())
+}
+
+// trim trims s to fit the width of the terminal, keeping its end,
+// where the base name of a path is.
+func (p *picker) trim(s string) string {
+	r := []rune(s)
+	if len(r) < p.cols {
+		return s
+	}
+	return "…" + string(r[len(r)-p.cols+2:])
+}
+
+// clear erases the picker from the terminal.
+func (p *picker) clear() {
+	p.tty.WriteString("\r\033[J")
+}
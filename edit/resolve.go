@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mariusae/tools/internal/gomod"
+)
+
+// projectMarkers are the files whose presence marks the root of a
+// project.
+var projectMarkers = []string{"go.mod", ".git"}
+
+// projectRoots returns the directories containing a project marker,
+// from the current directory upward, nearest first.
+func projectRoots() []string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	var roots []string
+	for {
+		for _, m := range projectMarkers {
+			if _, err := os.Stat(filepath.Join(dir, m)); err == nil {
+				roots = append(roots, dir)
+				break
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return roots
+		}
+		dir = parent
+	}
+}
+
+// searchDirs returns the directories named by arg, a path that is not
+// explicitly relative or absolute. It may be below an $EDITPATH
+// directory or a project root, or be a Go import path, resolved
+// through the go.mod files of the project roots, their replace
+// directives, and the module cache, or in the standard library.
+func searchDirs(arg string, roots []string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	add := func(dir string) {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			return
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			if seen[abs] {
+				return
+			}
+			seen[abs] = true
+		}
+		dirs = append(dirs, dir)
+	}
+	for _, prefix := range filepath.SplitList(os.Getenv("EDITPATH")) {
+		if prefix != "" {
+			add(filepath.Join(prefix, arg))
+		}
+	}
+	for _, root := range roots {
+		add(filepath.Join(root, arg))
+	}
+	for _, root := range roots {
+		f, err := gomod.Read(root)
+		if err != nil {
+			continue
+		}
+		if dir, ok := f.PackageDir(arg); ok {
+			add(dir)
+		}
+	}
+	if goroot := gomod.GOROOT(); goroot != "" {
+		add(filepath.Join(goroot, "src", filepath.FromSlash(arg)))
+	}
+	return dirs
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mariusae/tools/internal/content"
+	"github.com/mariusae/tools/internal/grep"
+	"github.com/ulikunitz/xz"
+)
+
+// maxArchiveDepth limits how deeply archives within archives
+// are searched.
+const maxArchiveDepth = 4
+
+// maxExpanded is the size above which archives, and the decompressed
+// streams and archive members within them that are read into memory,
+// are not searched, unless -max-filesize is given, so that a small
+// archive cannot expand to fill memory.
+const maxExpanded = 256 << 20
+
+// errTooLarge reports a stream or member over the size limit.
+var errTooLarge = errors.New("too large")
+
+// expandLimit returns the size limit for decompressed streams and
+// archive members.
+func expandLimit() int64 {
+	if maxFilesize > 0 {
+		return int64(maxFilesize)
+	}
+	return maxExpanded
+}
+
+// readLimited reads r to the end, returning errTooLarge if it holds
+// more than expandLimit bytes.
+func readLimited(r io.Reader) ([]byte, error) {
+	limit := expandLimit()
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+	return data, err
+}
+
+// tooLarge logs, with -v, that name was not searched for its size.
+func tooLarge(name string) {
+	if *vflag {
+		log.Printf("too large %s", name)
+	}
+}
+
+// An archiveKind identifies a compressed stream or archive format.
+type archiveKind int
+
+const (
+	notArchive archiveKind = iota
+	gzipStream
+	bzip2Stream
+	xzStream
+	zstdStream
+	tarArchive
+	zipArchive
+)
+
+// sniffArchive identifies the format of data by its magic number.
+func sniffArchive(data []byte) archiveKind {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return gzipStream
+	case bytes.HasPrefix(data, []byte("BZh")):
+		return bzip2Stream
+	case bytes.HasPrefix(data, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return xzStream
+	case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return zstdStream
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return zipArchive
+	case len(data) >= 262 && string(data[257:262]) == "ustar":
+		return tarArchive
+	}
+	return notArchive
+}
+
+// isArchive reports whether the file at path is compressed or an
+// archive.
+func isArchive(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var data [512]byte
+	n, _ := io.ReadFull(f, data[:])
+	return sniffArchive(data[:n]) != notArchive
+}
+
+// searchArchives returns a file search function that searches
+// compressed files and archives found by -z, passing any other
+// file to fn. Archives are read as streams, except that zip files
+// within other archives or compressed streams are read into memory.
+func searchArchives(fn func(g *grep.Grep, path string)) func(g *grep.Grep, path string) {
+	return func(g *grep.Grep, path string) {
+		if !isArchive(path) {
+			fn(g, path)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(g.Stderr, "%s\n", err)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			fmt.Fprintf(g.Stderr, "%s\n", err)
+			return
+		}
+		if info.Size() > expandLimit() {
+			tooLarge(path)
+			return
+		}
+		searchArchive(g, path, f, 0)
+	}
+}
+
+// searchArchive searches the content of the file name, read from r.
+// Compressed streams are searched after decompression under the
+// same name; members of archives are named archive!member.
+func searchArchive(g *grep.Grep, name string, r io.Reader, depth int) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	kind := sniffArchive(head)
+	if kind != notArchive && depth >= maxArchiveDepth {
+		fmt.Fprintf(g.Stderr, "%s: archives nested too deeply\n", name)
+		return
+	}
+	var dr io.Reader // the decompressed stream
+	var err error
+	switch kind {
+	case notArchive:
+		if content.Sniff(head) == content.Binary && !*binaryFlag {
+			return
+		}
+		data, err := readLimited(br)
+		if err == errTooLarge {
+			tooLarge(name)
+			return
+		} else if err != nil {
+			fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+			return
+		}
+		g.Bytes(data, name)
+		return
+
+	case gzipStream:
+		dr, err = gzip.NewReader(br)
+	case bzip2Stream:
+		dr = bzip2.NewReader(br)
+	case xzStream:
+		dr, err = xz.NewReader(br)
+	case zstdStream:
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(br)
+		if err == nil {
+			defer d.Close()
+			dr = d
+		}
+
+	case tarArchive:
+		tr := tar.NewReader(br)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+				break
+			}
+			if !hdr.FileInfo().Mode().IsRegular() {
+				continue
+			}
+			if hdr.Size > expandLimit() {
+				tooLarge(name + "!" + hdr.Name)
+				continue
+			}
+			searchArchive(g, name+"!"+hdr.Name, tr, depth+1)
+		}
+		return
+
+	case zipArchive:
+		ra, size, err := readerAt(r, br)
+		if err == errTooLarge {
+			tooLarge(name)
+			return
+		} else if err != nil {
+			fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+			return
+		}
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+			return
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			if f.UncompressedSize64 > uint64(expandLimit()) {
+				tooLarge(name + "!" + f.Name)
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				fmt.Fprintf(g.Stderr, "%s!%s: %v\n", name, f.Name, err)
+				continue
+			}
+			searchArchive(g, name+"!"+f.Name, rc, depth+1)
+			rc.Close()
+		}
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+		return
+	}
+	searchArchive(g, name, dr, depth+1)
+}
+
+// readerAt returns the content of r, which br buffers, for random
+// access, with its size. A file is read in place; other streams are
+// read into memory.
+func readerAt(r io.Reader, br *bufio.Reader) (io.ReaderAt, int64, error) {
+	if f, ok := r.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+	data, err := readLimited(br)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// types maps the names accepted by -t and -T to the file name
+// globs that make up each type.
+var types = map[string][]string{
+	"asm":     {"*.s", "*.S"},
+	"bazel":   {"BUILD", "BUILD.bazel", "WORKSPACE", "*.bzl"},
+	"c":       {"*.c", "*.h"},
+	"cpp":     {"*.cc", "*.cpp", "*.cxx", "*.hh", "*.hpp", "*.hxx", "*.h"},
+	"css":     {"*.css", "*.scss", "*.sass", "*.less"},
+	"docker":  {"Dockerfile", "*.dockerfile"},
+	"go":      {"*.go"},
+	"gomod":   {"go.mod", "go.sum", "go.work"},
+	"haskell": {"*.hs", "*.lhs"},
+	"html":    {"*.html", "*.htm", "*.xhtml"},
+	"java":    {"*.java"},
+	"js":      {"*.js", "*.jsx", "*.mjs", "*.cjs"},
+	"json":    {"*.json"},
+	"make":    {"Makefile", "makefile", "GNUmakefile", "*.mk", "mkfile"},
+	"md":      {"*.md", "*.markdown"},
+	"ocaml":   {"*.ml", "*.mli", "*.mll", "*.mly"},
+	"proto":   {"*.proto"},
+	"py":      {"*.py", "*.pyi"},
+	"rust":    {"*.rs"},
+	"scala":   {"*.scala", "*.sbt"},
+	"sh":      {"*.sh", "*.bash", "*.zsh", "*.rc"},
+	"sql":     {"*.sql"},
+	"tex":     {"*.tex", "*.sty", "*.cls", "*.bib"},
+	"toml":    {"*.toml"},
+	"ts":      {"*.ts", "*.tsx", "*.mts", "*.cts"},
+	"yaml":    {"*.yaml", "*.yml"},
+}
+
+// A stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// A sizeFlag is a flag giving a number of bytes, optionally with
+// a K, M, or G suffix for multiples of 1024.
+type sizeFlag int64
+
+func (f *sizeFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *sizeFlag) Set(s string) error {
+	num, mult := s, int64(1)
+	if i := strings.IndexAny(s, "KMGkmg"); i >= 0 && i == len(s)-1 {
+		num, mult = s[:i], 1<<(10*(strings.IndexByte("kmg", s[i]|0x20)+1))
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("bad size %q", s)
+	}
+	*f = sizeFlag(n * mult)
+	return nil
+}
+
+// A glob reports whether a slash-separated path, relative to the
+// walk root, matches a pattern.
+type glob func(rel string) bool
+
+// newGlob returns a glob for pattern. Patterns containing "..." are
+// matched with matchPattern against the relative path; other patterns
+// containing a slash are matched with path.Match against the
+// relative path, and the rest against the base name.
+func newGlob(pattern string) (glob, error) {
+	if _, err := path.Match(strings.Replace(pattern, "...", "*", -1), ""); err != nil {
+		return nil, fmt.Errorf("bad glob %q: %v", pattern, err)
+	}
+	switch {
+	case strings.Contains(pattern, "..."):
+		return matchPattern(pattern), nil
+	case strings.Contains(pattern, "/"):
+		return func(rel string) bool {
+			ok, _ := path.Match(pattern, rel)
+			return ok
+		}, nil
+	default:
+		return func(rel string) bool {
+			ok, _ := path.Match(pattern, path.Base(rel))
+			return ok
+		}, nil
+	}
+}
+
+// A filter selects the files to search by type and glob.
+type filter struct {
+	include []glob // at least one must match, if any
+	exclude []glob // none may match
+	prune   []glob // directories not to descend
+}
+
+// newFilter builds a filter from the -t, -T, and -g flag values.
+// Globs prefixed with "!" exclude the paths they match.
+func newFilter(typesIn, typesOut, globs []string) (*filter, error) {
+	f := new(filter)
+	add := func(list *[]glob, pattern string) error {
+		g, err := newGlob(pattern)
+		if err != nil {
+			return err
+		}
+		*list = append(*list, g)
+		return nil
+	}
+	for _, names := range [][]string{typesIn, typesOut} {
+		for _, name := range names {
+			if _, ok := types[name]; !ok {
+				return nil, fmt.Errorf("unknown file type %q; see -typelist", name)
+			}
+		}
+	}
+	for _, name := range typesIn {
+		for _, pattern := range types[name] {
+			if err := add(&f.include, pattern); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range typesOut {
+		for _, pattern := range types[name] {
+			if err := add(&f.exclude, pattern); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, pattern := range globs {
+		var err error
+		if strings.HasPrefix(pattern, "!") {
+			if err = add(&f.exclude, pattern[1:]); err == nil {
+				f.prune = append(f.prune, f.exclude[len(f.exclude)-1])
+			}
+		} else {
+			err = add(&f.include, pattern)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// fileOk reports whether the file at rel, relative to the walk
+// root, passes the filter.
+func (f *filter) fileOk(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, match := range f.exclude {
+		if match(rel) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, match := range f.include {
+		if match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirOk reports whether the directory at rel should be descended.
+// Only excluding -g globs prune directories; types apply to files.
+func (f *filter) dirOk(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, match := range f.prune {
+		if match(rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// printTypes writes the type table to w.
+func printTypes(w io.Writer) {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %s\n", name, strings.Join(types[name], ", "))
+	}
+}
@@ -3,73 +3,164 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	goregexp "regexp"
+	"runtime"
 	"strings"
 
+	"github.com/google/codesearch/index"
 	"github.com/google/codesearch/regexp"
+	"github.com/mariusae/tools/internal/content"
+	"github.com/mariusae/tools/internal/grep"
+	"github.com/mariusae/tools/internal/walker"
 )
 
 var iflag = flag.Bool("i", false, "case insensitive match")
 var vflag = flag.Bool("v", false, "verbose")
+var jflag = flag.Int("j", runtime.NumCPU(), "number of files to search in parallel")
+var uflag = flag.Bool("u", false, "do not honor .gitignore, .gignore, and .git/info/exclude files")
+var typelistFlag = flag.Bool("typelist", false, "list the file types known to -t and -T")
+var indexFlag = flag.Bool("index", false, "build a trigram index for each path instead of searching, kept in the path, or if it cannot be written, under $GPATH")
+var noindexFlag = flag.Bool("noindex", false, "do not consult trigram indexes")
+var rflag = flag.String("r", "", "replace matches with `replacement`, which may refer to submatches as $1")
+var wflag = flag.Bool("w", false, "with -r, rewrite files instead of printing a diff")
+var binaryFlag = flag.Bool("binary", false, "search binary files too, reporting only whether they match")
+var zflag = flag.Bool("z", false, "search inside compressed files and tar and zip archives, of up to 256M unless -max-filesize is given")
+var Lflag = flag.Bool("L", false, "follow symbolic links")
+var xdevFlag = flag.Bool("xdev", false, "do not descend into directories on other file systems")
+var watchFlag = flag.Bool("watch", false, "search, and then search again as files change, printing matches gained and lost")
+var scopeFlag = flag.String("scope", "", "in Go files, report only matches within `class`: comment, string, code, or ident")
+var maxTotalFlag = flag.Int("max-total", 0, "stop searching once `n` matches have been reported")
+
+// maxFilesize is the size above which files are not searched, or 0.
+var maxFilesize sizeFlag
+
+var (
+	typeFlags    stringList
+	notTypeFlags stringList
+	globFlags    stringList
+)
+
+// pathFilter holds the file selection given by -t, -T, and -g.
+var pathFilter *filter
+
+// query is the trigram query for the search, used to consult any
+// index covering the walk roots. It is nil when indexes are not used.
+var query *index.Query
+
+func init() {
+	flag.Var(&typeFlags, "t", "search only files of `type` (may be repeated)")
+	flag.Var(&notTypeFlags, "T", "do not search files of `type` (may be repeated)")
+	flag.Var(&globFlags, "g", "search only files matching `glob`, or not matching if prefixed with '!' (may be repeated)")
+	flag.Var(&maxFilesize, "max-filesize", "do not search files, or with -z decompressed files and archive members, larger than `size`, which may have a K, M, or G suffix")
+	flag.Var(patternFlag{}, "e", "search for `pattern` (may be repeated, and combined with -and, -or, and -not)")
+	flag.Var(opFlag("and"), "and", "with -e, select files matching both the preceding and following patterns")
+	flag.Var(opFlag("or"), "or", "with -e, select files matching either the preceding or following patterns")
+	flag.Var(opFlag("not"), "not", "with -e, select files not matching the following pattern")
+}
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "g: query [match..]\n")
+	fmt.Fprintf(os.Stderr, "g: -e query [-and|-or|-not] [-e query..] [match..]\n")
+	fmt.Fprintf(os.Stderr, "g: -r replacement [-w] query [match..]\n")
+	fmt.Fprintf(os.Stderr, "g: -index [path..]\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
-func walk(path string, g *regexp.Grep) {
+// walk calls fn for each searchable file under root, in lexical order,
+// until fn returns false. It reports whether the walk was completed.
+func walk(root string, fn func(path string) bool) bool {
+	return walkFiles(root, func(path string, info os.FileInfo) bool {
+		return !contentOk(path) || fn(path)
+	})
+}
+
+// walkFiles is like walk, but it calls fn with the description of
+// each file before its content is checked, leaving that to fn.
+func walkFiles(root string, fn func(path string, info os.FileInfo) bool) bool {
 	if *vflag {
-		log.Printf("walk %s %s", path, g.Regexp)
+		log.Printf("walk %s", root)
 	}
 
-	paths := []string{path}
-
-	_, err := os.Stat(path)
-	if err != nil && os.IsNotExist(err) {
-		paths = filepath.SplitList(os.Getenv("GPATH"))
-		for i, dir := range paths {
-			paths[i] = filepath.Join(dir, path)
-		}
-	} else if err != nil {
-		// Ignore
-		return
+	root = filepath.Clean(root)
+	var ix *treeIndex
+	abs, err := filepath.Abs(root)
+	if err == nil && query != nil {
+		ix = openIndex(root, query)
+	}
+	w := walker.New(root)
+	w.Follow = *Lflag
+	w.SameDevice = *xdevFlag
+	w.Ignore = !*uflag
+	w.Prune = func(path string, info os.FileInfo) bool {
+		return pruned(root, path, info)
 	}
 	if *vflag {
-		log.Printf("walk paths %v", paths)
+		w.Logf = log.Printf
 	}
+	for w.Next() {
+		path, info := w.Path(), w.Info()
+		if *vflag {
+			log.Printf("walk %s", path)
+		}
 
-	for _, root := range paths {
-		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if *vflag {
-				log.Printf("walk %s", path)
+		if info.IsDir() {
+			if dirHook != nil {
+				dirHook(path)
 			}
+			continue
+		}
 
-			if err != nil || info == nil {
-				return nil
-			}
+		if ix != nil && ix.skip(filepath.Join(abs, rel(root, path)), info) {
+			continue
+		}
 
-			if info.Mode().IsDir() {
-				switch filepath.Base(path) {
-				case ".git", ".svn", "_build", "node_modules", ".mypy_cache":
-					return filepath.SkipDir
-				default:
-					return nil
-				}
+		if maxFilesize > 0 && info.Size() > int64(maxFilesize) {
+			if *vflag {
+				log.Printf("too large %s", path)
 			}
+			continue
+		}
 
-			if info.Mode().IsRegular() && pathOk(path) && contentOk(path) {
-				g.File(path)
-			}
+		if info.Mode().IsRegular() && pathOk(path) && !fn(path, info) {
+			return false
+		}
+	}
+	if *vflag {
+		for _, err := range w.Errors() {
+			log.Print(err)
+		}
+	}
+	return true
+}
+
+// pruned reports whether the walk of root skips path, described by
+// info, and if it is a directory, its contents.
+func pruned(root, path string, info os.FileInfo) bool {
+	if !info.IsDir() {
+		return !pathFilter.fileOk(rel(root, path))
+	}
+	switch filepath.Base(path) {
+	case indexDir:
+		return true
+	case "_build", "node_modules", ".mypy_cache":
+		if !*uflag {
+			return true
+		}
+	}
+	return !pathFilter.dirOk(rel(root, path))
+}
 
-			return nil
-		})
+// rel returns path relative to the walk root.
+func rel(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
 	}
+	return rel
 }
 
 func pathOk(path string) bool {
@@ -82,43 +173,22 @@ func pathOk(path string) bool {
 }
 
 func contentOk(path string) bool {
-	switch filepath.Ext(path) {
-	case ".a", ".pkg":
-		return false
-	}
-
-	f, err := os.Open(path)
-	if err != nil {
-		return false
+	if *binaryFlag || *zflag && isArchive(path) {
+		return true
 	}
-	defer f.Close()
-
-	var data [512]byte
 
-	n, err := io.ReadFull(f, data[:])
-	if n == 0 && (err == io.ErrUnexpectedEOF || err == io.EOF) {
-		return true
-	} else if n <= 0 {
+	switch filepath.Ext(path) {
+	case ".a", ".pkg":
 		return false
 	}
 
-	// This may be too strict -- we could also implement
-	// something similar to silver searcher's heuristic.
-	tpe := http.DetectContentType(data[:n])
-
-	/*
-		if !strings.HasPrefix(tpe, "text/") {
-			log.Printf("Bad content type \"%s\" for %s", tpe, path)
-		}
-	*/
-
-	return strings.HasPrefix(tpe, "text/")
+	return content.IsText(path)
 }
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("g: ")
-	var g regexp.Grep
+	var g grep.Grep
 	g.AddFlags()
 	g.Stdout = os.Stdout
 	g.Stderr = os.Stderr
@@ -126,32 +196,152 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
-	args := flag.Args()
-	if len(args) == 0 {
-		usage()
+	if *typelistFlag {
+		printTypes(os.Stdout)
+		return
 	}
 
-	pat := "(?m)" + args[0]
-	if *iflag {
-		pat = "(?i)" + pat
+	args := flag.Args()
+	if len(args) == 0 && len(terms) == 0 && !*indexFlag {
+		usage()
 	}
 
-	re, err := regexp.Compile(pat)
+	var err error
+	pathFilter, err = newFilter(typeFlags, notTypeFlags, globFlags)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	g.Regexp = re
+	if *indexFlag {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		for _, root := range resolve(args) {
+			if err := buildIndex(root); err != nil {
+				log.Fatalf("index %s: %v", root, err)
+			}
+		}
+		return
+	}
+
+	compile := func(pat string) (*regexp.Regexp, error) {
+		pat = "(?m)" + pat
+		if *iflag {
+			pat = "(?i)" + pat
+		}
+		return regexp.Compile(pat)
+	}
 
-	if len(args) == 1 {
-		walk(".", &g)
+	// With -e, every argument is a path, and the patterns are
+	// combined into an expression selecting the files to search.
+	// Within those files, the lines matching any pattern that is
+	// not negated are printed; if all are negated, the files are
+	// listed.
+	var re *regexp.Regexp
+	if len(terms) > 0 {
+		expr, err := parseQuery(terms, compile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if expr.Op == grep.ExprMatch {
+			re = expr.Regexp
+		} else {
+			g.Files = expr
+			pat := linePattern(expr)
+			if pat == "" {
+				pat, g.L = "(?m)^", true
+			}
+			if re, err = regexp.Compile(pat); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if !*noindexFlag {
+			query = indexQuery(expr)
+		}
 	} else {
-		for _, path := range args[1:] {
-			walk(path, &g)
+		re, err = compile(args[0])
+		if err != nil {
+			log.Fatal(err)
 		}
+		args = args[1:]
+		if !*noindexFlag {
+			query = index.RegexpQuery(re.Syntax)
+		}
+	}
+	// The index rules out just the files -files-without-match lists.
+	if g.NotL {
+		query = nil
+	}
+	g.Regexp = re
+	if *scopeFlag != "" {
+		if g.Scope, err = newScope(*scopeFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fn := (*grep.Grep).File
+	replace := false
+	flag.Visit(func(f *flag.Flag) {
+		replace = replace || f.Name == "r"
+	})
+	if replace {
+		// Files are rewritten as they are searched, so the
+		// searcher cannot search again a file that overran a
+		// limit.
+		switch {
+		case len(terms) > 0:
+			log.Fatal("-r cannot be used with -e")
+		case *maxTotalFlag > 0:
+			log.Fatal("-max-total cannot be used with -r")
+		case g.M > 0:
+			log.Fatal("-m cannot be used with -r")
+		}
+		r, err := newReplacer(re.String(), *rflag, *wflag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fn = r.File
+	} else if *wflag {
+		log.Fatal("-w requires -r")
+	}
+	if *zflag {
+		if replace {
+			log.Fatal("-z cannot be used with -r")
+		}
+		fn = searchArchives(fn)
 	}
 
-	if !g.Match {
+	paths := []string{"."}
+	if len(args) > 0 {
+		paths = resolve(args)
+	}
+	if *watchFlag {
+		switch {
+		case replace:
+			log.Fatal("-watch cannot be used with -r")
+		case g.JSON:
+			log.Fatal("-watch cannot be used with -json")
+		case *maxTotalFlag > 0:
+			log.Fatal("-watch cannot be used with -max-total")
+		}
+		log.Fatal(watch(&g, fn, paths, os.Stdout))
+	}
+
+	s, err := newSearcher(*jflag, *maxTotalFlag, &g, os.Stdout, fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, root := range paths {
+		if !walk(root, s.Search) {
+			break
+		}
+	}
+
+	match, stats := s.Wait()
+	if g.JSON && !replace {
+		grep.WriteSummary(os.Stdout, stats)
+	}
+	if !match {
 		os.Exit(1)
 	}
 }
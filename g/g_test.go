@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs g itself when the tests run the test binary with
+// $G_TEST_MAIN set, so that they can check its output and status.
+func TestMain(m *testing.M) {
+	if os.Getenv("G_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// g runs g with args in dir, returning its output and exit status.
+func g(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "G_TEST_MAIN=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if e, ok := err.(*exec.ExitError); ok {
+		return string(out), e.ExitCode()
+	} else if err != nil {
+		t.Fatalf("g %v: %v\n%s", args, err, stderr.Bytes())
+	}
+	return string(out), 0
+}
+
+// tree creates the files, named by slash-separated paths, in a
+// temporary directory, which it returns.
+func tree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFilesWithoutMatchIndexed(t *testing.T) {
+	dir := tree(t, map[string]string{
+		"d/e.txt": "alpha\nbeta\n",
+		"d/f.txt": "alpha\ngamma\n",
+	})
+	if out, status := g(t, dir, "-index", "."); status != 0 {
+		t.Fatalf("g -index: status %d\n%s", status, out)
+	}
+	for _, args := range [][]string{
+		{"-files-without-match", "gamma", "."},
+		{"-noindex", "-files-without-match", "gamma", "."},
+	} {
+		out, status := g(t, dir, args...)
+		if want := "d/e.txt\n"; out != want || status != 0 {
+			t.Errorf("g %v = %q, status %d; want %q, status 0", args, out, status, want)
+		}
+	}
+}
+
+func TestTypeAsm(t *testing.T) {
+	dir := tree(t, map[string]string{
+		"a.s":   "mov\n",
+		"b.S":   "mov\n",
+		"c.asm": "mov\n", // never searched; see pathOk
+		"d.go":  "mov\n",
+	})
+	out, status := g(t, dir, "-noindex", "-t", "asm", "mov", ".")
+	if want := "a.s:1:mov\nb.S:1:mov\n"; out != want || status != 0 {
+		t.Errorf("g -t asm = %q, status %d; want %q, status 0", out, status, want)
+	}
+}
+
+func TestMaxTotalFilesWithoutMatch(t *testing.T) {
+	dir := tree(t, map[string]string{
+		"a.txt": "alpha\n",
+		"b.txt": "beta\n",
+		"c.txt": "gamma\n",
+	})
+	out, status := g(t, dir, "-noindex", "-max-total", "2", "-files-without-match", "zzz", ".")
+	if want := "a.txt\nb.txt\n"; out != want || status != 0 {
+		t.Errorf("g -max-total 2 -files-without-match = %q, status %d; want %q, status 0", out, status, want)
+	}
+}
+
+func TestReplaceScope(t *testing.T) {
+	dir := tree(t, map[string]string{
+		"a.go": "package p\n\n// foo\nvar foo = \"foo\"\n",
+	})
+	out, status := g(t, dir, "-noindex", "-scope=comment", "-r", "bar", "-w", "foo", ".")
+	if out != "a.go\n" || status != 0 {
+		t.Fatalf("g -scope=comment -r = %q, status %d", out, status)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package p\n\n// bar\nvar foo = \"foo\"\n"; string(data) != want {
+		t.Errorf("a.go = %q; want %q", data, want)
+	}
+}
@@ -2,4 +2,11 @@ module github.com/mariusae/tools/g
 
 go 1.15
 
-require github.com/google/codesearch v1.2.0
+require (
+	github.com/google/codesearch v1.2.0
+	github.com/klauspost/compress v1.13.6
+	github.com/mariusae/tools/internal v0.0.0
+	github.com/ulikunitz/xz v0.5.10
+)
+
+replace github.com/mariusae/tools/internal => ../internal
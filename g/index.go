@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/codesearch/index"
+)
+
+// An index is kept in the directory indexDir at the top of the tree
+// it covers, or if the tree cannot be written, as with the module
+// cache, in a directory named by a hash of the tree's absolute path
+// in indexTrees below indexDir in a $GPATH entry. It holds the
+// trigram index itself, and a list of the size and modification time
+// of each indexed file so that files changed since indexing can be
+// detected and scanned directly.
+const (
+	indexDir   = ".gindex"
+	indexTrees = "trees"
+	indexFile  = "index"
+	statFile   = "files"
+	indexTemp  = ".tmp"
+	statFormat = "%d %d %s\n"
+)
+
+// indexDirs returns the directories where the index of the tree at
+// the absolute path abs may be kept, in order of preference.
+func indexDirs(abs string) []string {
+	dirs := []string{filepath.Join(abs, indexDir)}
+	sum := sha1.Sum([]byte(abs))
+	for _, gpath := range filepath.SplitList(os.Getenv("GPATH")) {
+		if gpath != "" {
+			dirs = append(dirs, filepath.Join(gpath, indexDir, indexTrees, hex.EncodeToString(sum[:])))
+		}
+	}
+	return dirs
+}
+
+// A fileStat is the recorded size and modification time of a file.
+type fileStat struct {
+	size  int64
+	mtime int64
+}
+
+func statOf(info os.FileInfo) fileStat {
+	return fileStat{info.Size(), info.ModTime().UnixNano()}
+}
+
+// A treeIndex answers which files in an indexed tree may match
+// a query.
+type treeIndex struct {
+	dir        string              // top of the indexed tree
+	stats      map[string]fileStat // indexed files, relative to dir
+	candidates map[string]bool     // files that may match the query
+}
+
+// buildIndex indexes the searchable files under root, writing the
+// index to the first of its indexDirs that can be written.
+func buildIndex(root string) error {
+	root = filepath.Clean(root)
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	dir, err := createIndexDir(abs)
+	if err != nil {
+		return err
+	}
+	ixpath := filepath.Join(dir, indexFile)
+	ix := index.Create(ixpath + indexTemp)
+	ix.LogSkip = *vflag
+	ix.AddPaths([]string{abs})
+	stats := make(map[string]fileStat)
+	walk(root, func(p string) bool {
+		f, err := os.Open(p)
+		if err != nil {
+			log.Print(err)
+			return true
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			log.Print(err)
+			return true
+		}
+		name := filepath.ToSlash(rel(root, p))
+		stats[name] = statOf(info)
+		ix.Add(name, f)
+		return true
+	})
+	ix.Flush()
+	if err := os.Rename(ixpath+indexTemp, ixpath); err != nil {
+		return err
+	}
+
+	// The index writer silently skips files it does not consider
+	// text. Record only the files that made it into the index;
+	// the rest are always scanned.
+	statpath := filepath.Join(dir, statFile)
+	f, err := os.Create(statpath + indexTemp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	n := 0
+	for _, name := range indexNames(index.Open(ixpath)) {
+		st, ok := stats[name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, statFormat, st.size, st.mtime, name)
+		n++
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if *vflag {
+		log.Printf("index %s: %d files in %s", root, n, dir)
+	}
+	return os.Rename(statpath+indexTemp, statpath)
+}
+
+// createIndexDir creates, if need be, the first of the indexDirs of
+// the tree at abs in which files can be created, and returns it.
+func createIndexDir(abs string) (string, error) {
+	var first error
+	for _, dir := range indexDirs(abs) {
+		err := os.MkdirAll(dir, 0777)
+		if err == nil {
+			var f *os.File
+			if f, err = ioutil.TempFile(dir, indexTemp); err == nil {
+				f.Close()
+				os.Remove(f.Name())
+				return dir, nil
+			}
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return "", first
+}
+
+// indexNames returns the names of the files in ix.
+func indexNames(ix *index.Index) []string {
+	var names []string
+	for id := uint32(0); ; id++ {
+		name := ix.Name(id)
+		if name == "" {
+			return names
+		}
+		names = append(names, name)
+	}
+}
+
+// openIndex finds the index covering root, if any, by looking for
+// the index of root and each of its parents, and runs the query q
+// against it. It returns nil if there is no usable index.
+func openIndex(root string, q *index.Query) *treeIndex {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		for _, ixdir := range indexDirs(dir) {
+			if _, err := os.Stat(filepath.Join(ixdir, statFile)); err != nil {
+				continue
+			}
+			t, err := readIndex(dir, ixdir, q)
+			if err != nil {
+				log.Printf("index %s: %v", ixdir, err)
+				return nil
+			}
+			return t
+		}
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+// readIndex reads the index in ixdir of the tree at dir, and runs
+// the query q against it.
+func readIndex(dir, ixdir string, q *index.Query) (*treeIndex, error) {
+	t := &treeIndex{
+		dir:        dir,
+		stats:      make(map[string]fileStat),
+		candidates: make(map[string]bool),
+	}
+	f, err := os.Open(filepath.Join(ixdir, statFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		fields := strings.SplitN(scan.Text(), " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("bad line %q", scan.Text())
+		}
+		size, err1 := strconv.ParseInt(fields[0], 10, 64)
+		mtime, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("bad line %q", scan.Text())
+		}
+		t.stats[fields[2]] = fileStat{size, mtime}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	ix := index.Open(filepath.Join(ixdir, indexFile))
+	if *vflag {
+		log.Printf("index %s: query %s", ixdir, q)
+	}
+	for _, id := range ix.PostingQuery(q) {
+		t.candidates[ix.Name(id)] = true
+	}
+	return t, nil
+}
+
+// skip reports whether the file at abs, which is described by info,
+// can be skipped because it is unchanged since it was indexed and
+// the index shows it cannot match.
+func (t *treeIndex) skip(abs string, info os.FileInfo) bool {
+	name, err := filepath.Rel(t.dir, abs)
+	if err != nil {
+		return false
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	st, ok := t.stats[name]
+	if !ok || st != statOf(info) {
+		return false
+	}
+	return !t.candidates[name]
+}
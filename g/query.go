@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/regexp"
+	"github.com/mariusae/tools/internal/grep"
+)
+
+// A term is an element of a boolean query: a pattern given by -e,
+// or one of the operators -and, -or, and -not. Terms are kept in
+// command-line order.
+type term struct {
+	op      string // "e", "and", "or", or "not"
+	pattern string // for "e"
+}
+
+var terms []term
+
+// A patternFlag adds a -e pattern to terms.
+type patternFlag struct{}
+
+func (patternFlag) String() string { return "" }
+
+func (patternFlag) Set(s string) error {
+	terms = append(terms, term{op: "e", pattern: s})
+	return nil
+}
+
+// An opFlag adds a boolean operator to terms.
+type opFlag string
+
+func (opFlag) String() string   { return "" }
+func (opFlag) IsBoolFlag() bool { return true }
+
+func (f opFlag) Set(s string) error {
+	if s != "true" {
+		return fmt.Errorf("-%s takes no value", string(f))
+	}
+	terms = append(terms, term{op: string(f)})
+	return nil
+}
+
+// A queryParser parses terms into an expression. As with find,
+// -not binds tightest, then -and, then -or; adjacent patterns
+// are joined by an implicit -and.
+type queryParser struct {
+	terms   []term
+	compile func(pattern string) (*regexp.Regexp, error)
+}
+
+// parseQuery parses terms, compiling each pattern with compile.
+func parseQuery(terms []term, compile func(string) (*regexp.Regexp, error)) (*grep.Expr, error) {
+	p := &queryParser{terms: terms, compile: compile}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.terms) > 0 {
+		return nil, fmt.Errorf("unexpected -%s", p.terms[0].op)
+	}
+	return e, nil
+}
+
+func (p *queryParser) or() (*grep.Expr, error) {
+	e, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.next("or") {
+		f, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		e = join(grep.ExprOr, e, f)
+	}
+	return e, nil
+}
+
+func (p *queryParser) and() (*grep.Expr, error) {
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for len(p.terms) > 0 && p.terms[0].op != "or" {
+		p.next("and")
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		e = join(grep.ExprAnd, e, f)
+	}
+	return e, nil
+}
+
+func (p *queryParser) unary() (*grep.Expr, error) {
+	if len(p.terms) == 0 {
+		return nil, fmt.Errorf("missing pattern at end of query")
+	}
+	t := p.terms[0]
+	p.terms = p.terms[1:]
+	switch t.op {
+	case "not":
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &grep.Expr{Op: grep.ExprNot, Sub: []*grep.Expr{e}}, nil
+	case "e":
+		re, err := p.compile(t.pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &grep.Expr{Op: grep.ExprMatch, Regexp: re}, nil
+	}
+	return nil, fmt.Errorf("missing pattern before -%s", t.op)
+}
+
+// next consumes the next term if it is the operator op.
+func (p *queryParser) next(op string) bool {
+	if len(p.terms) > 0 && p.terms[0].op == op {
+		p.terms = p.terms[1:]
+		return true
+	}
+	return false
+}
+
+// join combines e and f with op, flattening nested uses of op.
+func join(op grep.ExprOp, e, f *grep.Expr) *grep.Expr {
+	if e.Op == op {
+		e.Sub = append(e.Sub, f)
+		return e
+	}
+	return &grep.Expr{Op: op, Sub: []*grep.Expr{e, f}}
+}
+
+// linePattern returns the pattern selecting the lines printed for
+// e: the alternation of its patterns that are not negated. It
+// returns "" if every pattern is negated.
+func linePattern(e *grep.Expr) string {
+	var alts []string
+	for _, re := range e.Positive() {
+		alts = append(alts, "(?:"+re.String()+")")
+	}
+	return strings.Join(alts, "|")
+}
+
+// indexQuery returns the trigram query for the files that may
+// satisfy e. A negated pattern can be satisfied by any file.
+func indexQuery(e *grep.Expr) *index.Query {
+	switch e.Op {
+	case grep.ExprMatch:
+		return index.RegexpQuery(e.Regexp.Syntax)
+	case grep.ExprNot:
+		return &index.Query{Op: index.QAll}
+	}
+	q := &index.Query{Op: index.QAnd}
+	if e.Op == grep.ExprOr {
+		q.Op = index.QOr
+	}
+	for _, sub := range e.Sub {
+		q.Sub = append(q.Sub, indexQuery(sub))
+	}
+	return q
+}
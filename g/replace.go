@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	goregexp "regexp"
+
+	"github.com/mariusae/tools/internal/content"
+	"github.com/mariusae/tools/internal/grep"
+)
+
+// diffContext is the number of unchanged lines around each hunk
+// of a replacement diff.
+const diffContext = 3
+
+// A replacer rewrites the matches of a regular expression in files.
+// As with searching, matches do not span lines, and each line is
+// rewritten independently.
+type replacer struct {
+	re    *goregexp.Regexp
+	repl  []byte // replacement, with $1-style submatch references
+	write bool   // write files rather than printing diffs
+}
+
+func newReplacer(pattern, repl string, write bool) (*replacer, error) {
+	re, err := goregexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &replacer{re: re, repl: []byte(repl), write: write}, nil
+}
+
+// File replaces the matches in the file at path, reporting through
+// g as a search would. It prints a diff of the change, or with
+// write, rewrites the file and prints its name.
+func (r *replacer) File(g *grep.Grep, path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(g.Stderr, "%s\n", err)
+		return
+	}
+	g.Stats.Files++
+	if content.Sniff(data) != content.Text || g.Regexp.Match(data, true, true) < 0 {
+		// Only UTF-8 text is rewritten.
+		return
+	}
+	// As when searching, only matches within a single one of the
+	// scope's ranges count.
+	var scope [][]int
+	scoped := false
+	if g.Scope != nil {
+		scope, scoped = g.Scope(path, data)
+	}
+	old := splitLines(data)
+	new := make([][]byte, len(old))
+	changed := 0
+	off := 0 // of the next line in data
+	for i, line := range old {
+		lineOff := off
+		off += len(line)
+		text := bytes.TrimSuffix(line, nl)
+		if !r.re.Match(text) {
+			new[i] = line
+			continue
+		}
+		repl := r.replace(text, func(start, end int) bool {
+			return !scoped || inRanges(scope, lineOff+start, lineOff+end)
+		})
+		new[i] = append(repl, line[len(text):]...)
+		if !bytes.Equal(new[i], line) {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return
+	}
+	g.Match = true
+	g.Stats.Matched++
+	g.Stats.Matches += changed
+	if !r.write {
+		writeDiff(g.Stdout, path, old, new)
+		return
+	}
+	if err := writeFileAtomic(path, bytes.Join(new, nil)); err != nil {
+		fmt.Fprintf(g.Stderr, "%s\n", err)
+		return
+	}
+	fmt.Fprintf(g.Stdout, "%s\n", path)
+}
+
+// replace returns text with the matches for which ok reports true
+// replaced.
+func (r *replacer) replace(text []byte, ok func(start, end int) bool) []byte {
+	var out []byte
+	last := 0
+	for _, m := range r.re.FindAllSubmatchIndex(text, -1) {
+		if !ok(m[0], m[1]) {
+			continue
+		}
+		out = append(out, text[last:m[0]]...)
+		out = r.re.Expand(out, r.repl, text, m)
+		last = m[1]
+	}
+	return append(out, text[last:]...)
+}
+
+var nl = []byte{'\n'}
+
+// splitLines splits data into lines, each retaining its newline.
+func splitLines(data []byte) [][]byte {
+	var lines [][]byte
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		lines = append(lines, data[:i])
+		data = data[i:]
+	}
+	return lines
+}
+
+// writeDiff writes a unified diff between old and new, which are
+// corresponding lines; a new line may contain several lines if the
+// replacement introduced newlines.
+func writeDiff(w io.Writer, path string, old, new [][]byte) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", path, path)
+	var changes []int
+	for i := range old {
+		if !bytes.Equal(old[i], new[i]) {
+			changes = append(changes, i)
+		}
+	}
+	for len(changes) > 0 {
+		// Gather the changes close enough to share a hunk.
+		n := 1
+		for n < len(changes) && changes[n]-changes[n-1] <= 2*diffContext {
+			n++
+		}
+		start := changes[0] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[n-1] + diffContext + 1
+		if end > len(old) {
+			end = len(old)
+		}
+		changes = changes[n:]
+
+		var hunk bytes.Buffer
+		oldn, newn := 0, 0
+		for i := start; i < end; i++ {
+			if bytes.Equal(old[i], new[i]) {
+				writeDiffLine(&hunk, ' ', old[i])
+				oldn++
+				newn++
+				continue
+			}
+			writeDiffLine(&hunk, '-', old[i])
+			oldn++
+			for _, line := range splitLines(new[i]) {
+				writeDiffLine(&hunk, '+', line)
+				newn++
+			}
+		}
+		// Line numbers in the new file shift by the lines added
+		// in earlier hunks.
+		newstart := start
+		for i := 0; i < start; i++ {
+			newstart += len(splitLines(new[i])) - 1
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", start+1, oldn, newstart+1, newn)
+		w.Write(hunk.Bytes())
+	}
+}
+
+func writeDiffLine(w *bytes.Buffer, op byte, line []byte) {
+	w.WriteByte(op)
+	w.Write(line)
+	if !bytes.HasSuffix(line, nl) {
+		w.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// writeFileAtomic replaces the contents of the file at path with data,
+// writing a temporary file in the same directory and renaming it over
+// the original so that readers never see a partial file. The original
+// permissions are preserved.
+func writeFileAtomic(path string, data []byte) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".g")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
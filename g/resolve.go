@@ -0,0 +1,265 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mariusae/tools/internal/gomod"
+	"github.com/mariusae/tools/internal/walker"
+)
+
+// resolve returns the roots to search for the path arguments args.
+// An argument that names an existing file or directory is used as
+// is. Otherwise it is looked up in each $GPATH entry, and failing
+// that, as a Go package or module path. Arguments containing "..."
+// are patterns, as understood by matchPattern, naming every matching
+// directory. Roots contained in other roots are dropped.
+func resolve(args []string) []string {
+	var roots []string
+	for _, arg := range args {
+		r := resolveArg(arg)
+		if len(r) == 0 {
+			log.Printf("%s: not found in the current directory, $GPATH, or Go modules", arg)
+		}
+		roots = append(roots, r...)
+	}
+	roots = dedup(roots)
+	if *vflag {
+		log.Printf("roots %s", strings.Join(roots, " "))
+	}
+	return roots
+}
+
+// explain logs, with -v, how an argument was resolved.
+func explain(arg, format string, args ...interface{}) {
+	if *vflag {
+		log.Printf("%s: "+format, append([]interface{}{arg}, args...)...)
+	}
+}
+
+func resolveArg(arg string) []string {
+	local := filepath.IsAbs(arg) || arg == "." || arg == ".." ||
+		strings.HasPrefix(arg, "./") || strings.HasPrefix(arg, "../")
+
+	if strings.Contains(arg, "...") {
+		base, pattern := ".", arg
+		if local {
+			base, pattern = splitPattern(arg)
+		}
+		roots := expandPattern(base, pattern)
+		explain(arg, "pattern matched %d directories in %s", len(roots), base)
+		if len(roots) > 0 || local {
+			return roots
+		}
+	} else if _, err := os.Stat(arg); err == nil {
+		explain(arg, "exists")
+		return []string{arg}
+	} else if !os.IsNotExist(err) || local {
+		log.Print(err)
+		return nil
+	} else {
+		explain(arg, "does not exist")
+	}
+
+	var roots []string
+	for _, dir := range filepath.SplitList(os.Getenv("GPATH")) {
+		if dir == "" {
+			continue
+		}
+		if strings.Contains(arg, "...") {
+			r := expandPattern(dir, arg)
+			explain(arg, "pattern matched %d directories in $GPATH entry %s", len(r), dir)
+			roots = append(roots, r...)
+			continue
+		}
+		p := filepath.Join(dir, arg)
+		if _, err := os.Stat(p); err != nil {
+			explain(arg, "not in $GPATH entry %s: %v", dir, err)
+			continue
+		}
+		explain(arg, "found in $GPATH entry %s", dir)
+		roots = append(roots, p)
+	}
+	if len(roots) > 0 {
+		return roots
+	}
+	if os.Getenv("GPATH") == "" {
+		explain(arg, "$GPATH is not set")
+	}
+	return goDirs(arg)
+}
+
+// splitPattern splits a local pattern such as ../x/... into the
+// directory it is relative to and the pattern proper.
+func splitPattern(arg string) (base, pattern string) {
+	i := strings.Index(arg, "...")
+	base = arg[:strings.LastIndex(arg[:i], "/")+1]
+	pattern = arg[len(base):]
+	if base == "" {
+		base = "."
+	}
+	return filepath.Clean(base), pattern
+}
+
+// expandPattern returns the directories below base whose path
+// relative to base matches pattern. The directories below a
+// matching directory are not considered separately. The directories
+// are walked as in a search, honoring ignore files, -u, -L, and -xdev.
+func expandPattern(base, pattern string) []string {
+	match := matchPattern(pattern)
+	// Only the directory named by the literal prefix of the
+	// pattern need be walked.
+	prefix := pattern[:strings.Index(pattern, "...")]
+	start := filepath.Join(base, filepath.FromSlash(path.Dir(prefix+"x")))
+	var roots []string
+	matched := func(p string) bool {
+		if !match(filepath.ToSlash(rel(base, p))) {
+			return false
+		}
+		roots = append(roots, p)
+		return true
+	}
+	w := walker.New(start)
+	w.Follow = *Lflag
+	w.SameDevice = *xdevFlag
+	w.Ignore = !*uflag
+	w.Prune = func(p string, info os.FileInfo) bool {
+		return !info.IsDir() || pruned(base, p, info) || matched(p)
+	}
+	if *vflag {
+		w.Logf = log.Printf
+	}
+	for w.Next() {
+		if w.Depth() == 0 && (!w.Info().IsDir() || matched(w.Path())) {
+			break
+		}
+	}
+	return roots
+}
+
+// goDirs resolves arg as a Go module, package, or package pattern,
+// in the current module, the modules it requires or replaces, or the
+// standard library, and failing that, in the module cache. The go
+// command is not run, so nothing is downloaded or rewritten.
+func goDirs(arg string) []string {
+	importPath, pattern := arg, ""
+	if i := strings.Index(arg, "..."); i >= 0 {
+		// Resolve the directory named by the literal prefix of
+		// the pattern, and match the rest below it.
+		importPath = path.Dir(arg[:i] + "x")
+		if importPath == "." {
+			return nil
+		}
+		pattern = arg[len(importPath)+1:]
+	}
+	var dirs []string
+	if dir := goDir(arg, importPath); dir != "" {
+		dirs = []string{dir}
+	} else {
+		dirs = modCacheDirs(importPath)
+	}
+	if pattern == "" {
+		return dirs
+	}
+	var roots []string
+	for _, dir := range dirs {
+		roots = append(roots, expandPattern(dir, pattern)...)
+	}
+	explain(arg, "pattern matched %d directories in %s", len(roots), importPath)
+	return roots
+}
+
+// goDir returns the directory of the module or package importPath in
+// the module containing the current directory, one it requires or
+// replaces, or the standard library, or "" if there is none.
+func goDir(arg, importPath string) string {
+	if f, err := gomod.Find("."); err != nil {
+		explain(arg, "no current module: %v", err)
+	} else if dir, ok := f.PackageDir(importPath); ok {
+		if _, err := os.Stat(dir); err == nil {
+			explain(arg, "found through module %s", f.Path)
+			return dir
+		}
+		explain(arg, "not found through module %s", f.Path)
+	}
+	// Standard library paths have no dot in their first element.
+	if strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		return ""
+	}
+	if goroot := gomod.GOROOT(); goroot != "" {
+		dir := filepath.Join(goroot, "src", filepath.FromSlash(importPath))
+		if _, err := os.Stat(dir); err == nil {
+			explain(arg, "in the standard library")
+			return dir
+		}
+	}
+	return ""
+}
+
+// modCacheDirs looks for arg in the module cache, trying each
+// prefix of arg as a module path and taking its latest version.
+func modCacheDirs(arg string) []string {
+	if gomod.Cache() == "" {
+		explain(arg, "no module cache")
+		return nil
+	}
+	elems := strings.Split(path.Clean(arg), "/")
+	for i := len(elems); i > 0; i-- {
+		mod := strings.Join(elems[:i], "/")
+		latest := gomod.Latest(mod)
+		if latest == "" {
+			continue
+		}
+		dir := filepath.Join(latest, filepath.FromSlash(strings.Join(elems[i:], "/")))
+		if _, err := os.Stat(dir); err != nil {
+			explain(arg, "module %s in the module cache: %v", mod, err)
+			return nil
+		}
+		explain(arg, "module %s in the module cache", mod)
+		return []string{dir}
+	}
+	explain(arg, "not in the module cache %s", gomod.Cache())
+	return nil
+}
+
+// dedup removes duplicate roots and roots inside other roots,
+// keeping the first occurrence of each.
+func dedup(roots []string) []string {
+	abs := make([]string, len(roots))
+	for i, root := range roots {
+		a, err := filepath.Abs(root)
+		if err != nil {
+			a = root
+		}
+		if e, err := filepath.EvalSymlinks(a); err == nil {
+			a = e
+		}
+		abs[i] = a
+	}
+	var out []string
+Roots:
+	for i, root := range roots {
+		for j := range roots {
+			if i == j {
+				continue
+			}
+			if abs[i] == abs[j] && j < i || within(abs[i], abs[j]) {
+				explain(root, "dropped: covered by %s", roots[j])
+				continue Roots
+			}
+		}
+		out = append(out, root)
+	}
+	return out
+}
+
+// within reports whether path is strictly inside the directory dir.
+func within(path, dir string) bool {
+	if dir == string(filepath.Separator) {
+		return path != dir
+	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
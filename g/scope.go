@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+	"sort"
+)
+
+// A tokenClass is a class of Go source text selected by -scope.
+type tokenClass int
+
+const (
+	commentClass tokenClass = iota // comments
+	stringClass                    // string and character literals
+	codeClass                      // everything but comments and literals
+	identClass                     // identifiers
+)
+
+var scopes = map[string]tokenClass{
+	"comment": commentClass,
+	"string":  stringClass,
+	"code":    codeClass,
+	"ident":   identClass,
+}
+
+// newScope returns a grep.Grep Scope function that restricts the
+// matches in Go files to the text of the named class. Other files
+// are searched as usual.
+func newScope(name string) (func(name string, data []byte) ([][]int, bool), error) {
+	class, ok := scopes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scope %q: want comment, string, code, or ident", name)
+	}
+	return func(name string, data []byte) ([][]int, bool) {
+		if filepath.Ext(name) != ".go" {
+			return nil, false
+		}
+		return goRanges(data, class), true
+	}, nil
+}
+
+// goRanges returns the sorted byte ranges of the Go source data
+// holding text of the given class. Source that does not scan
+// cleanly is classified as well as the scanner allows.
+func goRanges(data []byte, class tokenClass) [][]int {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(data))
+	var s scanner.Scanner
+	s.Init(file, data, nil, scanner.ScanComments)
+
+	var ranges [][]int
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		var c tokenClass
+		switch tok {
+		case token.COMMENT:
+			c = commentClass
+		case token.STRING, token.CHAR:
+			c = stringClass
+		case token.IDENT:
+			c = identClass
+		default:
+			continue
+		}
+		if c == identClass && class != identClass {
+			continue
+		}
+		if c != identClass && class != c && class != codeClass {
+			continue
+		}
+		off := file.Offset(pos)
+		ranges = append(ranges, []int{off, tokenEnd(data, off, lit)})
+	}
+	if class == codeClass {
+		return complement(ranges, len(data))
+	}
+	return ranges
+}
+
+// tokenEnd returns the offset just past the token with literal text
+// lit at offset off in data. The scanner removes carriage returns
+// from comments and raw strings, so their ends are found in data.
+func tokenEnd(data []byte, off int, lit string) int {
+	var start int
+	var delim string
+	switch {
+	case len(lit) >= 2 && lit[:2] == "/*":
+		start, delim = off+2, "*/"
+	case len(lit) >= 2 && lit[:2] == "//":
+		start, delim = off, "\n"
+	case len(lit) >= 1 && lit[0] == '`':
+		start, delim = off+1, "`"
+	default:
+		return off + len(lit)
+	}
+	i := bytes.Index(data[start:], []byte(delim))
+	if i < 0 {
+		// Unterminated.
+		return len(data)
+	}
+	if delim == "\n" {
+		return start + i
+	}
+	return start + i + len(delim)
+}
+
+// complement returns the ranges of [0, n) not covered by ranges.
+func complement(ranges [][]int, n int) [][]int {
+	var out [][]int
+	pos := 0
+	for _, r := range ranges {
+		if r[0] > pos {
+			out = append(out, []int{pos, r[0]})
+		}
+		pos = r[1]
+	}
+	if pos < n {
+		out = append(out, []int{pos, n})
+	}
+	return out
+}
+
+// inRanges reports whether [start, end) lies within a single one of
+// the sorted ranges.
+func inRanges(ranges [][]int, start, end int) bool {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i][1] >= end })
+	return i < len(ranges) && ranges[i][0] <= start
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/codesearch/regexp"
+	"github.com/mariusae/tools/internal/grep"
+)
+
+// A job is a single file to be searched. Its result is delivered
+// on res once a worker has finished with it.
+type job struct {
+	path string
+	res  chan result
+}
+
+// A result is the output of searching a single file.
+type result struct {
+	out   []byte
+	match bool
+	stats grep.Stats
+	n     int // matches reported, or with NotL, files listed
+}
+
+// A searcher greps files on a bounded pool of workers, emitting
+// their output in the order the files were submitted.
+type searcher struct {
+	jobs  chan *job
+	queue chan *job
+	wg    sync.WaitGroup
+	done  chan result
+	fn    func(g *grep.Grep, path string)
+	limit int        // matches to report before stopping, or 0
+	max   int        // proto's M, the matching lines to read in each file
+	redo  *grep.Grep // for searching again a file that overran the limit
+	total int64      // the results' n so far, kept atomically
+	stop  int32      // set atomically once limit is reached
+}
+
+// newSearcher starts n workers, each with its own Grep copied from
+// proto, and a printer that writes their results to w in order.
+// If limit is positive, the search stops once that many matches,
+// or with NotL, files, have been reported: each file is read for no
+// more matches than remain, and a file searched concurrently with
+// others that have since used up the remainder is searched again,
+// so fn must not change anything.
+// The regular expressions are recompiled for each worker since a
+// Regexp may not be shared between goroutines. Each file is
+// processed by calling fn with the worker's Grep.
+func newSearcher(n, limit int, proto *grep.Grep, w io.Writer, fn func(g *grep.Grep, path string)) (*searcher, error) {
+	if n < 1 {
+		n = 1
+	}
+	s := &searcher{
+		jobs:  make(chan *job),
+		queue: make(chan *job, 4*n),
+		done:  make(chan result, 1),
+		fn:    fn,
+		limit: limit,
+		max:   proto.M,
+	}
+	for i := 0; i <= n; i++ {
+		re, err := regexp.Compile(proto.Regexp.String())
+		if err != nil {
+			return nil, err
+		}
+		g := *proto
+		g.Regexp = re
+		if proto.Files != nil {
+			if g.Files, err = proto.Files.Clone(); err != nil {
+				return nil, err
+			}
+		}
+		if i == n {
+			s.redo = &g
+			break
+		}
+		s.wg.Add(1)
+		go s.work(&g)
+	}
+	go s.print(w)
+	return s, nil
+}
+
+// Search queues path to be searched. It reports whether the
+// search is still going; once it is not, path is ignored.
+func (s *searcher) Search(path string) bool {
+	if s.stopped() {
+		return false
+	}
+	j := &job{path: path, res: make(chan result, 1)}
+	s.queue <- j
+	s.jobs <- j
+	return true
+}
+
+func (s *searcher) stopped() bool {
+	return atomic.LoadInt32(&s.stop) != 0
+}
+
+// left returns the number of matching lines to read in the next file,
+// which is the -m limit lowered to the matches left before the total
+// limit, and reports whether any are left.
+func (s *searcher) left() (int, bool) {
+	if s.limit <= 0 {
+		return s.max, true
+	}
+	n := s.limit - int(atomic.LoadInt64(&s.total))
+	if n <= 0 {
+		return 0, false
+	}
+	if s.max > 0 && s.max < n {
+		n = s.max
+	}
+	return n, true
+}
+
+// Wait waits for all queued files to be searched and printed. It
+// reports whether any of them matched, and the total statistics.
+func (s *searcher) Wait() (bool, grep.Stats) {
+	close(s.jobs)
+	s.wg.Wait()
+	close(s.queue)
+	r := <-s.done
+	return r.match, r.stats
+}
+
+func (s *searcher) work(g *grep.Grep) {
+	defer s.wg.Done()
+	var buf bytes.Buffer
+	for j := range s.jobs {
+		if n, ok := s.left(); ok && !s.stopped() {
+			j.res <- s.search(g, &buf, j.path, n)
+		} else {
+			j.res <- result{}
+		}
+	}
+}
+
+// search searches path with g, reading at most n matching lines,
+// and returns the result written to buf.
+func (s *searcher) search(g *grep.Grep, buf *bytes.Buffer, path string, n int) result {
+	buf.Reset()
+	g.Stdout = buf
+	g.M = n
+	g.Match = false
+	g.Stats = grep.Stats{}
+	s.fn(g, path)
+	r := result{
+		out:   append([]byte(nil), buf.Bytes()...),
+		match: g.Match,
+		stats: g.Stats,
+		n:     g.Stats.Matches,
+	}
+	if g.NotL {
+		// Only files without a match are listed, and for
+		// those, Match is set.
+		r.n = 0
+		if g.Match {
+			r.n = 1
+		}
+	}
+	return r
+}
+
+func (s *searcher) print(w io.Writer) {
+	var total result
+	var buf bytes.Buffer
+	for j := range s.queue {
+		r := <-j.res
+		if s.stopped() {
+			continue
+		}
+		if left := s.limit - total.n; s.limit > 0 && r.n > left {
+			r = s.search(s.redo, &buf, j.path, left)
+		}
+		w.Write(r.out)
+		total.match = total.match || r.match
+		total.stats.Add(r.stats)
+		total.n += r.n
+		atomic.StoreInt64(&s.total, int64(total.n))
+		if s.limit > 0 && total.n >= s.limit {
+			atomic.StoreInt32(&s.stop, 1)
+		}
+	}
+	s.done <- total
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mariusae/tools/internal/grep"
+	"github.com/mariusae/tools/internal/walker"
+)
+
+// watchDelay is how long a watch waits for changes to settle before
+// searching again.
+const watchDelay = 100 * time.Millisecond
+
+// dirHook, if set, is called for each directory a walk enters.
+var dirHook func(dir string)
+
+// A watchedFile is a file searched by a watch, with the output of
+// its last search. Files that are not text are kept, without output,
+// so that they are not read again until they change.
+type watchedFile struct {
+	stat  fileStat
+	lines []string
+}
+
+// A watcher repeats a search whenever files change, reporting the
+// output lines that appear and disappear.
+type watcher struct {
+	g     *grep.Grep
+	fn    func(g *grep.Grep, path string)
+	roots []string
+	w     io.Writer
+	files map[string]*watchedFile
+}
+
+// watch searches roots with fn and g, printing the results to w,
+// and then watches them for changes. When files change, only those
+// the walk would search are searched again; changes to directories
+// or ignore files cause the roots to be walked again and the new or
+// changed files searched. The output lines gained and lost are
+// printed prefixed by + and -. Files are searched one at a time. It
+// returns only on error.
+func watch(g *grep.Grep, fn func(g *grep.Grep, path string), roots []string, w io.Writer) error {
+	n, err := newNotifier()
+	if err != nil {
+		return err
+	}
+	x := &watcher{
+		g:     g,
+		fn:    fn,
+		roots: roots,
+		w:     w,
+		files: make(map[string]*watchedFile),
+	}
+	dirHook = func(dir string) {
+		if err := n.add(dir); err != nil {
+			log.Printf("watch %s: %v", dir, err)
+		}
+	}
+	x.scan(true)
+	for {
+		changed, err := n.wait(watchDelay)
+		if err != nil {
+			return err
+		}
+		x.update(changed)
+	}
+}
+
+// ignoreNames are the names of ignore files, changes to which may
+// change the files to be searched.
+var ignoreNames = map[string]bool{
+	".gitignore": true,
+	".gignore":   true,
+	"exclude":    true,
+}
+
+// update searches again the files at the paths in changed that the
+// walk would search, and forgets those it no longer would. If an
+// ignore file is among them, or changed is nil, meaning that the
+// changes are not known, it scans the roots instead.
+func (x *watcher) update(changed map[string]bool) {
+	if changed == nil {
+		x.scan(false)
+		return
+	}
+	var paths []string
+	for path := range changed {
+		if ignoreNames[filepath.Base(path)] {
+			x.scan(false)
+			return
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err == nil && *Lflag && info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+		}
+		if err == nil && x.searchable(path, info) {
+			x.visit(path, info, false)
+		} else if f := x.files[path]; f != nil {
+			x.printDiff(path, f.lines, nil)
+			delete(x.files, path)
+		}
+	}
+}
+
+// searchable reports whether the walk of the roots would search the
+// file at path, described by info, in a directory the walk entered.
+// Other files, such as editors' swap files and build outputs, are not
+// searched, and their changes are not reported.
+func (x *watcher) searchable(path string, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || !pathOk(path) || maxFilesize > 0 && info.Size() > int64(maxFilesize) {
+		return false
+	}
+	for _, root := range x.roots {
+		root = filepath.Clean(root)
+		r, err := filepath.Rel(root, path)
+		if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if r == "." {
+			// A root is always searched.
+			return true
+		}
+		if pruned(root, path, info) {
+			return false
+		}
+		if *uflag {
+			return true
+		}
+		ig := walker.NewIgnorer(root)
+		dir := root
+		for _, name := range strings.Split(filepath.Dir(r), string(filepath.Separator)) {
+			if name != "." {
+				dir = filepath.Join(dir, name)
+				ig = ig.Enter(dir)
+			}
+		}
+		return !ig.Ignored(path, false)
+	}
+	return false
+}
+
+// scan walks the roots, searching the files that are new or have
+// changed since the last scan, and forgetting those that are gone.
+// Unless initial, only the differences in output are printed.
+func (x *watcher) scan(initial bool) {
+	seen := make(map[string]bool)
+	for _, root := range x.roots {
+		walkFiles(root, func(path string, info os.FileInfo) bool {
+			seen[path] = true
+			x.visit(path, info, initial)
+			return true
+		})
+	}
+	var gone []string
+	for path := range x.files {
+		if !seen[path] {
+			gone = append(gone, path)
+		}
+	}
+	sort.Strings(gone)
+	for _, path := range gone {
+		x.printDiff(path, x.files[path].lines, nil)
+		delete(x.files, path)
+	}
+}
+
+// visit searches the file at path, described by info, if it is new or
+// has changed. Unless initial, only the differences in output are
+// printed.
+func (x *watcher) visit(path string, info os.FileInfo, initial bool) {
+	f := x.files[path]
+	if f != nil && f.stat == statOf(info) {
+		return
+	}
+	var lines []string
+	if contentOk(path) {
+		lines = x.search(path)
+	}
+	if initial {
+		printLines(x.w, "", lines)
+	} else {
+		var old []string
+		if f != nil {
+			old = f.lines
+		}
+		x.printDiff(path, old, lines)
+	}
+	x.files[path] = &watchedFile{statOf(info), lines}
+}
+
+// search searches the file at path, returning its output lines.
+func (x *watcher) search(path string) []string {
+	var buf bytes.Buffer
+	g := *x.g
+	g.Stdout = &buf
+	x.fn(&g, path)
+	if buf.Len() == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+// printDiff prints the lines of new not in old with a + prefix,
+// and those of old not in new with a - prefix. Lines are compared
+// without their line numbers, so that matches moved by edits
+// elsewhere in the file are not reported.
+func (x *watcher) printDiff(path string, old, new []string) {
+	count := func(lines []string) map[string]int {
+		m := make(map[string]int)
+		for _, line := range lines {
+			m[lineKey(path, line)]++
+		}
+		return m
+	}
+	unique := func(lines []string, other map[string]int) []string {
+		var out []string
+		for _, line := range lines {
+			k := lineKey(path, line)
+			if other[k] > 0 {
+				other[k]--
+				continue
+			}
+			out = append(out, line)
+		}
+		return out
+	}
+	printLines(x.w, "-", unique(old, count(new)))
+	printLines(x.w, "+", unique(new, count(old)))
+}
+
+// lineKey returns the output line for the file path without its line
+// number, if it has one.
+func lineKey(path, line string) string {
+	rest := strings.TrimPrefix(line, path+":")
+	i := 0
+	for i < len(rest) && '0' <= rest[i] && rest[i] <= '9' {
+		i++
+	}
+	if i > 0 && i < len(rest) && rest[i] == ':' {
+		return rest[i+1:]
+	}
+	return line
+}
+
+func printLines(w io.Writer, prefix string, lines []string) {
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchMask selects the inotify events that trigger a new search.
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ATTRIB | syscall.IN_DELETE_SELF
+
+// A notifier reports changes to the files in a set of directories,
+// using inotify.
+type notifier struct {
+	fd     int
+	events chan error // nil for an event
+
+	mu      sync.Mutex
+	dirs    map[int32]string // watched directories, by watch descriptor
+	changed map[string]bool  // files changed since the last wait
+	unknown bool             // there were changes to other than files
+}
+
+func newNotifier() (*notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	n := &notifier{
+		fd:      fd,
+		events:  make(chan error, 1),
+		dirs:    make(map[int32]string),
+		changed: make(map[string]bool),
+	}
+	go n.read()
+	return n, nil
+}
+
+// add watches the directory dir. Adding a directory again is
+// harmless.
+func (n *notifier) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, watchMask)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.dirs[int32(wd)] = dir
+	n.mu.Unlock()
+	return nil
+}
+
+// read records the changes reported by inotify, and signals them on
+// n.events.
+func (n *notifier) read() {
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	for {
+		m, err := syscall.Read(n.fd, buf[:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			n.events <- err
+			return
+		}
+		n.mu.Lock()
+		for off := 0; off+syscall.SizeofInotifyEvent <= m; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+			n.note(ev.Wd, ev.Mask, string(bytes.TrimRight(name, "\x00")))
+		}
+		n.mu.Unlock()
+		select {
+		case n.events <- nil:
+		default:
+		}
+	}
+}
+
+// note records an event with mask for the file name in the directory
+// watched by wd. Events for directories, and lost events, make the
+// changes unknown. It is called with n.mu held.
+func (n *notifier) note(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(n.dirs, wd)
+		return
+	}
+	dir, ok := n.dirs[wd]
+	if !ok || name == "" || mask&(syscall.IN_ISDIR|syscall.IN_Q_OVERFLOW) != 0 {
+		n.unknown = true
+		return
+	}
+	n.changed[filepath.Join(dir, name)] = true
+}
+
+// wait waits for a change, and then until there have been no further
+// changes for the duration quiet. It returns the files that changed,
+// or nil if other changes were made.
+func (n *notifier) wait(quiet time.Duration) (map[string]bool, error) {
+	if err := <-n.events; err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case err := <-n.events:
+			if err != nil {
+				return nil, err
+			}
+		case <-time.After(quiet):
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			changed := n.changed
+			if n.unknown {
+				changed = nil
+			}
+			n.changed, n.unknown = make(map[string]bool), false
+			return changed, nil
+		}
+	}
+}
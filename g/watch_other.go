@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// A notifier reports changes to the files in a set of directories.
+// It is implemented only on Linux.
+type notifier struct{}
+
+func newNotifier() (*notifier, error) {
+	return nil, errors.New("-watch is not supported on this system")
+}
+
+func (n *notifier) add(dir string) error { return nil }
+
+func (n *notifier) wait(quiet time.Duration) (map[string]bool, error) { return nil, nil }
@@ -0,0 +1,136 @@
+// Package content classifies file contents as text or binary.
+//
+// It replaces the earlier practice of accepting only files that
+// http.DetectContentType sniffed as text/*, which rejected JSON, SVG,
+// UTF-16 text, and source files with unusual headers.
+package content
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// SniffLen is the number of leading bytes examined by Sniff.
+const SniffLen = 8192
+
+// A Kind is the classification of a file's contents.
+type Kind int
+
+const (
+	Text   Kind = iota // UTF-8 or ASCII text, or mostly so
+	UTF16              // UTF-16 text introduced by a byte order mark
+	Binary             // anything else
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Text:
+		return "text"
+	case UTF16:
+		return "utf-16"
+	default:
+		return "binary"
+	}
+}
+
+// maxBad is the fraction of bytes that may be invalid UTF-8 or
+// unexpected control characters before data is considered binary.
+const maxBad = 0.1
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Sniff classifies data, which is a prefix of a file's contents;
+// only the first SniffLen bytes are examined. Data containing a NUL
+// byte is binary unless it begins with a UTF-16 byte order mark.
+// Otherwise data is text unless more than a tenth of it is invalid
+// UTF-8 or control characters other than the usual whitespace.
+func Sniff(data []byte) Kind {
+	if len(data) > SniffLen {
+		data = data[:SniffLen]
+	}
+	if bytes.HasPrefix(data, bomUTF16LE) || bytes.HasPrefix(data, bomUTF16BE) {
+		return UTF16
+	}
+	data = bytes.TrimPrefix(data, bomUTF8)
+	if bytes.IndexByte(data, 0) >= 0 {
+		return Binary
+	}
+	bad := 0
+	for i := 0; i < len(data); {
+		c := data[i]
+		if c < utf8.RuneSelf {
+			if c < ' ' && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != '\v' && c != '\b' && c != 0x1b {
+				bad++
+			}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && n == 1 {
+			if !utf8.FullRune(data[i:]) {
+				// A rune cut off by the end of the sample.
+				break
+			}
+			bad++
+		}
+		i += n
+	}
+	if float64(bad) > maxBad*float64(len(data)) {
+		return Binary
+	}
+	return Text
+}
+
+// File classifies the file at path by its first SniffLen bytes.
+func File(path string) (Kind, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Binary, err
+	}
+	defer f.Close()
+	var data [SniffLen]byte
+	n, err := io.ReadFull(f, data[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Binary, err
+	}
+	return Sniff(data[:n]), nil
+}
+
+// IsText reports whether the file at path contains text, in UTF-8
+// or UTF-16. Files that cannot be read are not text.
+func IsText(path string) bool {
+	k, err := File(path)
+	return err == nil && k != Binary
+}
+
+// Decode returns data as UTF-8. UTF-16 text, as recognized by its
+// byte order mark, is converted; anything else is returned as is.
+func Decode(data []byte) []byte {
+	var order func([]byte) uint16
+	switch {
+	case bytes.HasPrefix(data, bomUTF16LE):
+		order = func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 }
+	case bytes.HasPrefix(data, bomUTF16BE):
+		order = func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) }
+	default:
+		return data
+	}
+	data = data[2:]
+	units := make([]uint16, 0, len(data)/2)
+	for ; len(data) >= 2; data = data[2:] {
+		units = append(units, order(data))
+	}
+	runes := utf16.Decode(units)
+	out := make([]byte, 0, len(runes))
+	for _, r := range runes {
+		out = append(out, string(r)...)
+	}
+	return out
+}
@@ -0,0 +1,5 @@
+module github.com/mariusae/tools/internal
+
+go 1.15
+
+require github.com/google/codesearch v1.2.0
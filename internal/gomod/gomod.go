@@ -0,0 +1,283 @@
+// Package gomod finds the directories of Go modules and packages by
+// reading go.mod files and looking in the module cache and GOROOT,
+// rather than by running the go command, so that g and edit can
+// resolve import paths cheaply and without changing anything.
+package gomod
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// A File is what is needed of a go.mod file to resolve import paths.
+type File struct {
+	Dir     string            // containing the go.mod file
+	Path    string            // the module path
+	Require map[string]string // version by module path
+	Replace map[string]Replacement
+}
+
+// A Replacement is the target of a replace directive: a module
+// version, or if Version is empty, a directory.
+type Replacement struct {
+	Path, Version string
+}
+
+// Read reads the go.mod file in dir. Only the module, require, and
+// replace directives are interpreted.
+func Read(dir string) (*File, error) {
+	fd, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	f := &File{
+		Dir:     dir,
+		Require: make(map[string]string),
+		Replace: make(map[string]Replacement),
+	}
+	block := ""
+	scan := bufio.NewScanner(fd)
+	for scan.Scan() {
+		line := scan.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if s, err := strconv.Unquote(field); err == nil {
+				fields[i] = s
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		verb := block
+		switch {
+		case block != "" && fields[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		case block == "":
+			verb, fields = fields[0], fields[1:]
+		}
+		switch verb {
+		case "module":
+			if len(fields) == 1 {
+				f.Path = fields[0]
+			}
+		case "require":
+			if len(fields) == 2 {
+				f.Require[fields[0]] = fields[1]
+			}
+		case "replace":
+			// old [version] => new [version]
+			for i, field := range fields {
+				if field != "=>" || i == 0 {
+					continue
+				}
+				switch to := fields[i+1:]; len(to) {
+				case 1:
+					f.Replace[fields[0]] = Replacement{Path: to[0]}
+				case 2:
+					f.Replace[fields[0]] = Replacement{to[0], to[1]}
+				}
+			}
+		}
+	}
+	return f, scan.Err()
+}
+
+// Find reads the go.mod file of the module containing dir, which is
+// the nearest one in dir or above it.
+func Find(dir string) (*File, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		f, err := Read(dir)
+		if !os.IsNotExist(err) {
+			return f, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, err
+		}
+		dir = parent
+	}
+}
+
+// PackageDir returns the directory of the package with import path
+// importPath, or of the module if it is a module path, if it is in
+// the module f or one it requires or replaces. The directory need not
+// exist.
+func (f *File) PackageDir(importPath string) (string, bool) {
+	if f.Path != "" && Within(importPath, f.Path) {
+		return filepath.Join(f.Dir, filepath.FromSlash(strings.TrimPrefix(importPath, f.Path))), true
+	}
+	// The module providing the package is the one with the longest
+	// path that is a prefix of the import path.
+	mod := ""
+	for p := range f.Require {
+		if Within(importPath, p) && len(p) > len(mod) {
+			mod = p
+		}
+	}
+	for p := range f.Replace {
+		if Within(importPath, p) && len(p) > len(mod) {
+			mod = p
+		}
+	}
+	if mod == "" {
+		return "", false
+	}
+	rest := filepath.FromSlash(strings.TrimPrefix(importPath, mod))
+	version := f.Require[mod]
+	if r, ok := f.Replace[mod]; ok {
+		if r.Version == "" {
+			dir := filepath.FromSlash(r.Path)
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(f.Dir, dir)
+			}
+			return filepath.Join(dir, rest), true
+		}
+		mod, version = r.Path, r.Version
+	}
+	dir := CacheDir(mod, version)
+	if dir == "" {
+		return "", false
+	}
+	return filepath.Join(dir, rest), true
+}
+
+// Within reports whether importPath is in the module with path mod.
+func Within(importPath, mod string) bool {
+	return importPath == mod || strings.HasPrefix(importPath, mod+"/")
+}
+
+// Cache returns the location of the module cache, or "" if it is
+// unknown.
+func Cache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		gopath = filepath.Join(home, "go")
+	}
+	return filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+}
+
+// CacheDir returns the directory of version of module mod in the
+// module cache, or "" if the cache is unknown. The directory need
+// not exist.
+func CacheDir(mod, version string) string {
+	cache := Cache()
+	if cache == "" {
+		return ""
+	}
+	return filepath.Join(cache, filepath.FromSlash(Escape(mod)+"@"+Escape(version)))
+}
+
+// Latest returns the directory of the latest version of module mod
+// in the module cache, or "" if there is none.
+func Latest(mod string) string {
+	cache := Cache()
+	if cache == "" {
+		return ""
+	}
+	matches, _ := filepath.Glob(filepath.Join(cache, filepath.FromSlash(Escape(mod))+"@*"))
+	if len(matches) == 0 {
+		return ""
+	}
+	version := func(dir string) string { return dir[strings.LastIndex(dir, "@")+1:] }
+	sort.Slice(matches, func(i, j int) bool {
+		return versionLess(version(matches[i]), version(matches[j]))
+	})
+	return matches[len(matches)-1]
+}
+
+// Escape escapes a module path or version as in the module cache,
+// replacing each upper-case letter by "!" and its lower-case form.
+func Escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// versionLess reports whether the semantic version v precedes w.
+// Release versions follow their prereleases.
+func versionLess(v, w string) bool {
+	vnum, vpre := splitVersion(v)
+	wnum, wpre := splitVersion(w)
+	for i := range vnum {
+		if vnum[i] != wnum[i] {
+			return vnum[i] < wnum[i]
+		}
+	}
+	switch {
+	case vpre == wpre:
+		return false
+	case vpre == "":
+		return false
+	case wpre == "":
+		return true
+	}
+	return vpre < wpre
+}
+
+func splitVersion(v string) (num [3]int, pre string) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v, pre = v[:i], v[i:]
+	}
+	for i, f := range strings.SplitN(v, ".", 3) {
+		num[i], _ = strconv.Atoi(f)
+	}
+	return num, pre
+}
+
+var (
+	gorootOnce sync.Once
+	goroot     string
+)
+
+// GOROOT returns the root of the Go installation, or "" if there is
+// none. The go command is run only if $GOROOT is unset and the
+// program was built without recording it.
+func GOROOT() string {
+	gorootOnce.Do(func() {
+		if goroot = os.Getenv("GOROOT"); goroot != "" {
+			return
+		}
+		if goroot = runtime.GOROOT(); goroot != "" {
+			return
+		}
+		out, err := exec.Command("go", "env", "GOROOT").Output()
+		if err == nil {
+			goroot = strings.TrimSpace(string(out))
+		}
+	})
+	return goroot
+}
@@ -0,0 +1,92 @@
+package grep
+
+import (
+	"github.com/google/codesearch/regexp"
+)
+
+// An ExprOp is the operator of an Expr.
+type ExprOp int
+
+const (
+	ExprMatch ExprOp = iota // the file contains a match for Regexp
+	ExprAnd                 // all of Sub hold
+	ExprOr                  // any of Sub holds
+	ExprNot                 // Sub[0] does not hold
+)
+
+// An Expr is a boolean combination of regular expressions that
+// decides, from its contents, whether a file is searched.
+type Expr struct {
+	Op     ExprOp
+	Regexp *regexp.Regexp // for ExprMatch
+	Sub    []*Expr
+}
+
+// Eval reports whether e holds for the file content data.
+func (e *Expr) Eval(data []byte) bool {
+	switch e.Op {
+	case ExprMatch:
+		return e.Regexp.Match(data, true, true) >= 0
+	case ExprAnd:
+		for _, sub := range e.Sub {
+			if !sub.Eval(data) {
+				return false
+			}
+		}
+		return true
+	case ExprOr:
+		for _, sub := range e.Sub {
+			if sub.Eval(data) {
+				return true
+			}
+		}
+		return false
+	case ExprNot:
+		return !e.Sub[0].Eval(data)
+	}
+	panic("grep: bad expression")
+}
+
+// Clone returns a copy of e with its regular expressions recompiled,
+// so that it may be used by another goroutine.
+func (e *Expr) Clone() (*Expr, error) {
+	c := &Expr{Op: e.Op}
+	if e.Regexp != nil {
+		re, err := regexp.Compile(e.Regexp.String())
+		if err != nil {
+			return nil, err
+		}
+		c.Regexp = re
+	}
+	for _, sub := range e.Sub {
+		s, err := sub.Clone()
+		if err != nil {
+			return nil, err
+		}
+		c.Sub = append(c.Sub, s)
+	}
+	return c, nil
+}
+
+// Positive returns the regular expressions in e that are not
+// negated; these select the lines printed from matching files.
+func (e *Expr) Positive() []*regexp.Regexp {
+	var res []*regexp.Regexp
+	var walk func(e *Expr, neg bool)
+	walk = func(e *Expr, neg bool) {
+		switch e.Op {
+		case ExprMatch:
+			if !neg {
+				res = append(res, e.Regexp)
+			}
+		case ExprNot:
+			walk(e.Sub[0], !neg)
+		default:
+			for _, sub := range e.Sub {
+				walk(sub, neg)
+			}
+		}
+	}
+	walk(e, false)
+	return res
+}
@@ -0,0 +1,407 @@
+// Package grep implements grep-style searching and output shared
+// by g and ag. It follows codesearch's regexp.Grep, adding context
+// lines, match-only output, and JSON records for use by editors.
+package grep
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	goregexp "regexp"
+	"sort"
+	"strconv"
+
+	"github.com/google/codesearch/regexp"
+	"github.com/mariusae/tools/internal/content"
+)
+
+// A Grep searches for a regular expression, printing results in the
+// manner of grep.
+type Grep struct {
+	Regexp *regexp.Regexp // regexp to search for
+	Stdout io.Writer      // output target
+	Stderr io.Writer      // error target
+
+	L    bool // L flag - print file names only
+	NotL bool // files-without-match flag - print names of files without matches only
+	C    bool // C flag - print count of matches
+	N    bool // N flag - print line numbers
+	H    bool // H flag - do not print file names
+	O    bool // O flag - print only the matched part of lines
+	A    int  // A flag - lines of trailing context
+	B    int  // B flag - lines of leading context
+	M    int  // M flag - stop after this many matching lines in each file
+	JSON bool // JSON flag - print a JSON record for each match
+
+	// Files, if non-nil, selects the files to search by their
+	// content; other files are skipped.
+	Files *Expr
+
+	// Scope, if non-nil, is called with the name and content of
+	// each text file. If it returns ok, only matches lying within
+	// one of the returned byte ranges, which are sorted, count.
+	Scope func(name string, data []byte) (ranges [][]int, ok bool)
+
+	// Match is set when a file is reported: one containing a match,
+	// or with NotL, one without.
+	Match bool
+	Stats Stats
+
+	span   *goregexp.Regexp // Regexp, for finding matches within a line
+	scoped bool             // Scope applies to the current file
+	scope  [][]int          // Scope ranges in the current file; none means no match counts
+}
+
+// Stats counts the work done by a Grep.
+type Stats struct {
+	Files   int // files searched
+	Matched int // files containing a match
+	Matches int // matches reported
+}
+
+// Add adds the counts in t to s.
+func (s *Stats) Add(t Stats) {
+	s.Files += t.Files
+	s.Matched += t.Matched
+	s.Matches += t.Matches
+}
+
+// contextFlag implements -C, which sets both -A and -B.
+type contextFlag struct{ g *Grep }
+
+func (f contextFlag) String() string {
+	if f.g == nil {
+		return "0"
+	}
+	return strconv.Itoa(f.g.A)
+}
+
+func (f contextFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	f.g.A, f.g.B = n, n
+	return nil
+}
+
+func (g *Grep) AddFlags() {
+	flag.BoolVar(&g.L, "l", false, "list matching files only")
+	flag.BoolVar(&g.C, "c", false, "print match counts only")
+	flag.BoolVar(&g.N, "n", false, "show line numbers")
+	flag.BoolVar(&g.H, "h", false, "omit file names")
+	flag.BoolVar(&g.O, "o", false, "print only the matched part of lines")
+	flag.IntVar(&g.A, "A", 0, "print `n` lines of trailing context")
+	flag.IntVar(&g.B, "B", 0, "print `n` lines of leading context")
+	flag.IntVar(&g.M, "m", 0, "stop reading a file after `n` matching lines")
+	flag.BoolVar(&g.NotL, "files-without-match", false, "list files without matches only")
+	flag.Var(contextFlag{g}, "C", "print `n` lines of context")
+	flag.BoolVar(&g.JSON, "json", false, "print a JSON record for each match")
+}
+
+func (g *Grep) File(name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		fmt.Fprintf(g.Stderr, "%s\n", err)
+		return
+	}
+	defer f.Close()
+	g.Reader(f, name)
+}
+
+func (g *Grep) Reader(r io.Reader, name string) {
+	if g.Files == nil && g.Scope == nil && g.listing() {
+		g.list(r, name)
+		return
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+		return
+	}
+	g.Bytes(data, name)
+}
+
+var nl = []byte{'\n'}
+
+// Bytes searches data, which is the content of the file name.
+// UTF-16 text is searched after conversion to UTF-8. Binary data
+// is searched as a whole, and a match is reported without printing
+// the matching lines.
+func (g *Grep) Bytes(data []byte, name string) {
+	kind := content.Sniff(data)
+	if kind == content.UTF16 {
+		data = content.Decode(data)
+	}
+	g.Stats.Files++
+	if g.Files != nil && !g.Files.Eval(data) {
+		if g.NotL {
+			g.listed(name, false)
+		}
+		return
+	}
+	if kind == content.Binary {
+		if g.listing() {
+			g.listed(name, g.Regexp.Match(data, true, true) >= 0)
+			return
+		}
+		g.binary(data, name)
+		return
+	}
+	g.scoped, g.scope = false, nil
+	if g.Scope != nil {
+		g.scope, g.scoped = g.Scope(name, data)
+	}
+
+	var (
+		prefix = ""
+		count  = 0
+		pos    = 0  // offset of the next line to search
+		lineno = 1  // number of the line at pos
+		last   = -1 // offset just past the last line printed
+		lastno = 0  // number of the line at last
+	)
+	if !g.H {
+		prefix = name
+	}
+	context := !g.C && !g.O && !g.JSON && (g.A > 0 || g.B > 0)
+	for pos < len(data) && (g.M <= 0 || count < g.M) {
+		m := g.Regexp.Match(data[pos:], pos == 0, true)
+		if m < 0 {
+			break
+		}
+		m += pos
+		start := bytes.LastIndex(data[pos:m], nl) + 1 + pos
+		end := m + 1
+		if end > len(data) {
+			end = len(data)
+		}
+		if g.scoped && len(g.spans(bytes.TrimSuffix(data[start:end], nl), start)) == 0 {
+			lineno += bytes.Count(data[pos:end], nl)
+			pos = end
+			continue
+		}
+		if g.listing() {
+			g.listed(name, true)
+			return
+		}
+		if count == 0 {
+			g.Stats.Matched++
+		}
+		g.Match = true
+		count++
+		lineno += bytes.Count(data[pos:start], nl)
+		switch {
+		case g.JSON:
+			g.writeJSON(name, data, start, end, lineno)
+		case g.C:
+			g.Stats.Matches++
+		case g.O:
+			g.printSpans(prefix, lineno, data[start:end], start)
+		default:
+			g.Stats.Matches++
+			if context {
+				// Finish the trailing context of the last
+				// match, then find the leading context of
+				// this one.
+				if last >= 0 {
+					last, lastno = g.printContext(prefix, data, last, lastno, start, g.A)
+				}
+				lo := pos
+				if last > lo {
+					lo = last
+				}
+				before, beforeno := start, lineno
+				for i := 0; i < g.B && before > lo; i++ {
+					before = bytes.LastIndex(data[lo:before-1], nl) + 1 + lo
+					beforeno--
+				}
+				if last >= 0 && before > last {
+					fmt.Fprintf(g.Stdout, "--\n")
+				}
+				g.printContext(prefix, data, before, beforeno, start, -1)
+			}
+			g.printLine(prefix, ':', lineno, data[start:end])
+			last, lastno = end, lineno+1
+		}
+		lineno++
+		pos = end
+	}
+	if g.listing() {
+		g.listed(name, false)
+		return
+	}
+	if context && last >= 0 {
+		g.printContext(prefix, data, last, lastno, len(data), g.A)
+	}
+	if g.C && !g.JSON && count > 0 {
+		fmt.Fprintf(g.Stdout, "%s: %d\n", name, count)
+	}
+}
+
+// binary searches data, the content of the binary file name.
+func (g *Grep) binary(data []byte, name string) {
+	if g.Regexp.Match(data, true, true) < 0 {
+		return
+	}
+	g.Match = true
+	g.Stats.Matched++
+	g.Stats.Matches++
+	switch {
+	case g.JSON:
+		json.NewEncoder(g.Stdout).Encode(BinaryRecord{Type: "binary", Path: name})
+	default:
+		fmt.Fprintf(g.Stdout, "%s: binary file matches\n", name)
+	}
+}
+
+// listing reports whether only file names are printed.
+func (g *Grep) listing() bool {
+	return g.NotL || g.L && !g.JSON
+}
+
+// listChunk is the size of the reads made by list.
+const listChunk = 64 << 10
+
+// list searches r, the content of the file name, reading only as far
+// as the first match, and prints name as directed by L or NotL.
+func (g *Grep) list(r io.Reader, name string) {
+	g.Stats.Files++
+	buf := make([]byte, listChunk)
+	keep := 0 // length of the partial line carried over
+	for first := true; ; first = false {
+		n, err := io.ReadFull(r, buf[keep:])
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+			return
+		}
+		data := buf[:keep+n]
+		if first && content.Sniff(data) == content.UTF16 {
+			rest, err := ioutil.ReadAll(r)
+			if err != nil {
+				fmt.Fprintf(g.Stderr, "%s: %v\n", name, err)
+				return
+			}
+			data = content.Decode(append(data, rest...))
+			g.listed(name, g.Regexp.Match(data, true, true) >= 0)
+			return
+		}
+		// Search whole lines, leaving any partial line at the end
+		// for the next read.
+		end := len(data)
+		if !eof {
+			end = bytes.LastIndexByte(data, '\n') + 1
+		}
+		if g.Regexp.Match(data[:end], first, eof) >= 0 {
+			g.listed(name, true)
+			return
+		}
+		if eof {
+			g.listed(name, false)
+			return
+		}
+		keep = copy(buf, data[end:])
+		if keep == len(buf) {
+			buf = append(buf, make([]byte, len(buf))...)
+		}
+	}
+}
+
+// listed records whether the file name matched, printing its name
+// if it is to be listed.
+func (g *Grep) listed(name string, matched bool) {
+	if matched {
+		g.Stats.Matched++
+		g.Stats.Matches++
+	}
+	if matched != g.NotL {
+		g.Match = true
+		fmt.Fprintf(g.Stdout, "%s\n", name)
+	}
+}
+
+// printContext prints at most n lines (all, if n < 0) of
+// data[start:end] as context. The line at start is numbered lineno.
+// It returns the offset and number of the line following the last
+// one printed.
+func (g *Grep) printContext(prefix string, data []byte, start, lineno, end, n int) (int, int) {
+	for ; start < end && n != 0; n-- {
+		i := bytes.IndexByte(data[start:end], '\n')
+		next := start + i + 1
+		if i < 0 {
+			next = end
+		}
+		g.printLine(prefix, '-', lineno, data[start:next])
+		start = next
+		lineno++
+	}
+	return start, lineno
+}
+
+// printLine prints a single line of output. Sep is ':' for matching
+// lines and '-' for context. With line numbers, a context line is
+// marked by a space in place of the colon after the number, so that
+// the prefix remains a valid address.
+func (g *Grep) printLine(prefix string, sep byte, lineno int, line []byte) {
+	nl := ""
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		nl = "\n"
+	}
+	switch {
+	case g.N && sep == ':':
+		if prefix != "" {
+			prefix += ":"
+		}
+		fmt.Fprintf(g.Stdout, "%s%d:%s%s", prefix, lineno, line, nl)
+	case g.N:
+		if prefix != "" {
+			prefix += ":"
+		}
+		fmt.Fprintf(g.Stdout, "%s%d %s%s", prefix, lineno, line, nl)
+	case prefix != "":
+		fmt.Fprintf(g.Stdout, "%s%c%s%s", prefix, sep, line, nl)
+	default:
+		fmt.Fprintf(g.Stdout, "%s%s", line, nl)
+	}
+}
+
+// spans returns the offsets of the non-empty matches within line,
+// which must not include its trailing newline. Off is the offset of
+// line in the file, for checking the matches against any scope.
+func (g *Grep) spans(line []byte, off int) [][]int {
+	if g.span == nil {
+		g.span = goregexp.MustCompile(g.Regexp.String())
+	}
+	var spans [][]int
+	for _, m := range g.span.FindAllIndex(line, -1) {
+		if m[0] < m[1] && g.inScope(off+m[0], off+m[1]) {
+			spans = append(spans, m)
+		}
+	}
+	return spans
+}
+
+// inScope reports whether the match at offsets [start, end) of the
+// current file lies within a single one of its scope ranges.
+func (g *Grep) inScope(start, end int) bool {
+	if !g.scoped {
+		return true
+	}
+	i := sort.Search(len(g.scope), func(i int) bool { return g.scope[i][1] >= end })
+	return i < len(g.scope) && g.scope[i][0] <= start
+}
+
+// printSpans prints each match within line, which is at offset off
+// in the file, on a line of its own.
+func (g *Grep) printSpans(prefix string, lineno int, line []byte, off int) {
+	line = bytes.TrimSuffix(line, nl)
+	for _, m := range g.spans(line, off) {
+		g.Stats.Matches++
+		g.printLine(prefix, ':', lineno, line[m[0]:m[1]])
+	}
+}
@@ -0,0 +1,109 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// A MatchRecord is printed for each match in JSON mode. Lines and
+// columns count from 1; columns and offsets are in bytes.
+type MatchRecord struct {
+	Type     string   `json:"type"` // "match"
+	Path     string   `json:"path"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Start    int      `json:"start"`     // offset of the match in the file
+	End      int      `json:"end"`       // offset just past the match
+	Text     string   `json:"text"`      // the matched text
+	LineText string   `json:"line_text"` // the line containing the match
+	Before   []string `json:"before,omitempty"`
+	After    []string `json:"after,omitempty"`
+}
+
+// A BinaryRecord is printed in JSON mode for a binary file that
+// contains a match.
+type BinaryRecord struct {
+	Type string `json:"type"` // "binary"
+	Path string `json:"path"`
+}
+
+// A SummaryRecord is printed at the end of a search in JSON mode.
+type SummaryRecord struct {
+	Type    string `json:"type"` // "summary"
+	Files   int    `json:"files"`
+	Matched int    `json:"matched"`
+	Matches int    `json:"matches"`
+}
+
+// WriteSummary writes the summary record for s to w.
+func WriteSummary(w io.Writer, s Stats) error {
+	return json.NewEncoder(w).Encode(SummaryRecord{
+		Type:    "summary",
+		Files:   s.Files,
+		Matched: s.Matched,
+		Matches: s.Matches,
+	})
+}
+
+// writeJSON writes a record for each match in the line data[start:end],
+// which is numbered lineno, of the file name.
+func (g *Grep) writeJSON(name string, data []byte, start, end, lineno int) {
+	line := bytes.TrimSuffix(data[start:end], nl)
+	var before, after []string
+	if g.B > 0 {
+		before = linesBefore(data, start, g.B)
+	}
+	if g.A > 0 {
+		after = linesAfter(data, end, g.A)
+	}
+	spans := g.spans(line, start)
+	if len(spans) == 0 {
+		// The line matched only empty strings, as with ^.
+		spans = [][]int{{0, 0}}
+	}
+	enc := json.NewEncoder(g.Stdout)
+	for _, m := range spans {
+		g.Stats.Matches++
+		enc.Encode(MatchRecord{
+			Type:     "match",
+			Path:     name,
+			Line:     lineno,
+			Column:   m[0] + 1,
+			Start:    start + m[0],
+			End:      start + m[1],
+			Text:     string(line[m[0]:m[1]]),
+			LineText: string(line),
+			Before:   before,
+			After:    after,
+		})
+	}
+}
+
+// linesBefore returns up to n lines preceding offset start, which
+// is the start of a line.
+func linesBefore(data []byte, start, n int) []string {
+	var lines []string
+	for ; n > 0 && start > 0; n-- {
+		i := bytes.LastIndex(data[:start-1], nl) + 1
+		lines = append([]string{string(data[i : start-1])}, lines...)
+		start = i
+	}
+	return lines
+}
+
+// linesAfter returns up to n lines following offset end, which
+// is the start of a line.
+func linesAfter(data []byte, end, n int) []string {
+	var lines []string
+	for ; n > 0 && end < len(data); n-- {
+		i := bytes.IndexByte(data[end:], '\n')
+		if i < 0 {
+			lines = append(lines, string(data[end:]))
+			break
+		}
+		lines = append(lines, string(data[end:end+i]))
+		end += i + 1
+	}
+	return lines
+}
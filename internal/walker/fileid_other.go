@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package walker
+
+import "os"
+
+// fileIDOf returns the identity of the file at path, described by
+// info. Device and inode numbers are not available here, so the
+// file is identified by its path with symbolic links resolved, and
+// all files appear to be on the same device.
+func fileIDOf(path string, info os.FileInfo) fileID {
+	return fileID{path: realPath(path)}
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package walker
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIDOf returns the identity of the file at path, described by
+// info, by its device and inode numbers.
+func fileIDOf(path string, info os.FileInfo) fileID {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{path: realPath(path)}
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+}
@@ -0,0 +1,285 @@
+package walker
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreNames are the per-directory ignore files, in increasing
+// order of precedence.
+var ignoreNames = []string{
+	".git/info/exclude",
+	".gitignore",
+	".gignore",
+}
+
+// An ignorePattern is a single pattern from an ignore file.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// An ignoreFile holds the patterns read from one ignore file. The
+// patterns apply to paths beneath dir. When the file lives above the
+// walk root, prefix is the path of the root relative to the file's
+// directory, and dir is the root itself.
+type ignoreFile struct {
+	dir      string
+	prefix   string
+	patterns []ignorePattern
+}
+
+// An ignorer is the set of ignore files in effect in a directory.
+// Files in deeper directories take precedence over their parents.
+type ignorer struct {
+	parent *ignorer
+	files  []*ignoreFile
+}
+
+// An Ignorer is the set of ignore files in effect in a directory, for
+// use by callers that read directories themselves.
+type Ignorer struct {
+	ig *ignorer
+}
+
+// NewIgnorer returns the Ignorer for the directory root, as a Walker
+// finds it for a root.
+func NewIgnorer(root string) *Ignorer {
+	return &Ignorer{newIgnorer(root)}
+}
+
+// Enter returns the Ignorer for the directory dir, whose parent
+// directory is governed by ig.
+func (ig *Ignorer) Enter(dir string) *Ignorer {
+	return &Ignorer{ig.ig.enter(dir)}
+}
+
+// Ignored reports whether the ignore files of ig exclude path, which
+// is in the directory ig governs.
+func (ig *Ignorer) Ignored(path string, isDir bool) bool {
+	return ig.ig.ignored(path, isDir)
+}
+
+// newIgnorer returns the ignorer for the walk root, including the
+// ignore files of its parent directories up to the top of the
+// enclosing git repository, if any.
+func newIgnorer(root string) *ignorer {
+	var ig *ignorer
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return ig.enter(root)
+	}
+	// Collect the parent directories up to the top of the
+	// repository. Outside a repository only the root's own
+	// ignore files apply.
+	var parents []string
+	if !exists(filepath.Join(abs, ".git")) {
+		for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+			parents = append(parents, dir)
+			if exists(filepath.Join(dir, ".git")) {
+				break
+			}
+			if dir == filepath.Dir(dir) {
+				parents = nil
+				break
+			}
+		}
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		prefix, err := filepath.Rel(parents[i], abs)
+		if err != nil {
+			continue
+		}
+		next := &ignorer{parent: ig}
+		for _, name := range ignoreNames {
+			f := readIgnoreFile(filepath.Join(parents[i], name))
+			if f == nil {
+				continue
+			}
+			f.dir = root
+			f.prefix = filepath.ToSlash(prefix)
+			next.files = append(next.files, f)
+		}
+		if len(next.files) > 0 {
+			ig = next
+		}
+	}
+	return ig.enter(root)
+}
+
+// enter returns the ignorer for directory dir, whose parent
+// directory is governed by ig. Ig may be nil.
+func (ig *ignorer) enter(dir string) *ignorer {
+	next := &ignorer{parent: ig}
+	for _, name := range ignoreNames {
+		f := readIgnoreFile(filepath.Join(dir, name))
+		if f == nil {
+			continue
+		}
+		f.dir = dir
+		next.files = append(next.files, f)
+	}
+	if len(next.files) == 0 {
+		return ig
+	}
+	return next
+}
+
+// ignored reports whether path should be skipped.
+func (ig *ignorer) ignored(path string, isDir bool) bool {
+	for ; ig != nil; ig = ig.parent {
+		for i := len(ig.files) - 1; i >= 0; i-- {
+			if matched, ignored := ig.files[i].match(path, isDir); matched {
+				return ignored
+			}
+		}
+	}
+	return false
+}
+
+// match reports whether any pattern in f matches path, and if so,
+// whether the last matching pattern ignores it.
+func (f *ignoreFile) match(path string, isDir bool) (matched, ignored bool) {
+	rel := path
+	if f.dir != "." {
+		if !strings.HasPrefix(path, f.dir+string(filepath.Separator)) {
+			return false, false
+		}
+		rel = path[len(f.dir)+1:]
+	}
+	rel = filepath.ToSlash(rel)
+	if f.prefix != "" {
+		rel = f.prefix + "/" + rel
+	}
+	for i := len(f.patterns) - 1; i >= 0; i-- {
+		p := f.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			return true, !p.negate
+		}
+	}
+	return false, false
+}
+
+// readIgnoreFile parses the ignore file at path, returning nil
+// if it does not exist or contains no patterns.
+func readIgnoreFile(path string) *ignoreFile {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+	f := new(ignoreFile)
+	scan := bufio.NewScanner(fd)
+	for scan.Scan() {
+		if p, ok := parseIgnorePattern(scan.Text()); ok {
+			f.patterns = append(f.patterns, p)
+		}
+	}
+	if len(f.patterns) == 0 {
+		return nil
+	}
+	return f
+}
+
+// parseIgnorePattern parses a line of a gitignore file as
+// described in gitignore(5).
+func parseIgnorePattern(line string) (p ignorePattern, ok bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// Trailing spaces are ignored unless quoted with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+	// A pattern with a slash other than at its end is relative to
+	// the directory of the ignore file; otherwise it may match at
+	// any level below it.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/") && (i == 0 || line[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case line[i:] == "**" && i > 0 && line[i-1] == '/':
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+			for i+1 < len(line) && line[i+1] == '*' {
+				i++
+			}
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			j := i + 1
+			if j < len(line) && (line[j] == '!' || line[j] == '^') {
+				j++
+			}
+			if j < len(line) && line[j] == ']' {
+				j++
+			}
+			for j < len(line) && line[j] != ']' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				re.WriteString(`\[`)
+				break
+			}
+			class := line[i+1 : j]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i = j
+		case c == '\\' && i+1 < len(line):
+			i++
+			re.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(line[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	var err error
+	p.re, err = regexp.Compile(re.String())
+	if err != nil {
+		return p, false
+	}
+	return p, true
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,253 @@
+// Package walker iterates over the files in directory trees, in the
+// manner shared by g and edit: in lexical order, honoring ignore files,
+// and optionally following symbolic links.
+//
+// It grew out of the Walker in edit/oldedit.
+package walker
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// vcsDirs are the version control directories that are never walked.
+var vcsDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+}
+
+// A Walker walks a set of trees, yielding each file and directory in
+// turn, each directory before its contents. Errors are accumulated
+// rather than ending the walk. A directory is not walked again below
+// itself, as a symbolic link followed to one of its ancestors would
+// have it; reached by other paths, it is walked again under each.
+//
+// The options must be set before the first call to Next.
+type Walker struct {
+	Follow     bool // follow symbolic links below the roots; roots are always followed
+	SameDevice bool // do not descend into directories on other devices than their root
+	MaxDepth   int  // if positive, do not descend below this depth; roots have depth 0
+	Ignore     bool // honor .gitignore, .gignore, and .git/info/exclude files
+
+	// Ignorer, if non-nil, is the set of ignore files in effect in
+	// the roots, which is otherwise found from the roots and their
+	// parent directories. It lets a walk of a directory continue
+	// one made of its parent.
+	Ignorer *Ignorer
+
+	// Prune, if non-nil, is called for each path below a root, and
+	// if it returns true the path, and if it is a directory, its
+	// contents, are skipped.
+	Prune func(path string, info os.FileInfo) bool
+
+	// Logf, if non-nil, is called to explain why paths are skipped.
+	Logf func(format string, args ...interface{})
+
+	todo    []*entry // in reverse order
+	cur     *entry
+	expand  bool // cur is a directory whose contents are to be walked
+	errs    []error
+	started bool
+}
+
+// An entry is a path to be walked.
+type entry struct {
+	path  string
+	root  string
+	info  os.FileInfo // nil for a root that has not yet been visited
+	depth int
+	dev   uint64   // device of the root
+	ig    *ignorer // for the path's directory; for a directory, its own
+	dir   *entry   // the directory containing the path, nil for a root
+	id    fileID   // for a directory, its identity
+}
+
+// New returns a Walker for the trees rooted at roots.
+func New(roots ...string) *Walker {
+	w := new(Walker)
+	for i := len(roots) - 1; i >= 0; i-- {
+		root := filepath.Clean(roots[i])
+		w.todo = append(w.todo, &entry{path: root, root: root})
+	}
+	return w
+}
+
+// Next advances to the next file or directory, returning false at
+// the end of the walk.
+func (w *Walker) Next() bool {
+	if w.expand {
+		w.expand = false
+		w.readDir(w.cur)
+	}
+	for len(w.todo) > 0 {
+		e := w.todo[len(w.todo)-1]
+		w.todo = w.todo[:len(w.todo)-1]
+		if !w.visit(e) {
+			continue
+		}
+		w.cur = e
+		w.expand = e.info.IsDir() && (w.MaxDepth <= 0 || e.depth < w.MaxDepth)
+		return true
+	}
+	w.cur = nil
+	return false
+}
+
+// visit reports whether e is to be yielded, completing it if so.
+func (w *Walker) visit(e *entry) bool {
+	if e.info == nil {
+		info, err := os.Stat(e.path)
+		if err != nil {
+			w.errs = append(w.errs, err)
+			return false
+		}
+		e.info = info
+		e.dev = fileIDOf(e.path, info).dev
+		switch {
+		case !w.Ignore:
+		case w.Ignorer != nil:
+			e.ig = w.Ignorer.ig
+		default:
+			e.ig = newIgnorer(e.path)
+		}
+	} else {
+		isDir := e.info.IsDir()
+		if isDir && vcsDirs[filepath.Base(e.path)] {
+			return false
+		}
+		if w.Ignore && e.ig.ignored(e.path, isDir) {
+			w.logf("ignore %s", e.path)
+			return false
+		}
+		if w.Prune != nil && w.Prune(e.path, e.info) {
+			w.logf("prune %s", e.path)
+			return false
+		}
+		if isDir && w.Ignore {
+			e.ig = e.ig.enter(e.path)
+		}
+	}
+	if !e.info.IsDir() {
+		return true
+	}
+	id := fileIDOf(e.path, e.info)
+	if w.SameDevice && id.dev != e.dev {
+		w.logf("xdev %s", e.path)
+		return false
+	}
+	for d := e.dir; d != nil; d = d.dir {
+		if d.id == id {
+			w.logf("cycle %s", e.path)
+			return false
+		}
+	}
+	e.id = id
+	return true
+}
+
+// readDir queues the contents of the directory e.
+func (w *Walker) readDir(e *entry) {
+	names, err := readDirNames(e.path)
+	if err != nil {
+		w.errs = append(w.errs, err)
+		return
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		path := filepath.Join(e.path, names[i])
+		info, err := os.Lstat(path)
+		if err == nil && w.Follow && info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+		}
+		if err != nil {
+			w.errs = append(w.errs, err)
+			continue
+		}
+		w.todo = append(w.todo, &entry{
+			path:  path,
+			root:  e.root,
+			info:  info,
+			depth: e.depth + 1,
+			dev:   e.dev,
+			ig:    e.ig,
+			dir:   e,
+		})
+	}
+}
+
+func (w *Walker) logf(format string, args ...interface{}) {
+	if w.Logf != nil {
+		w.Logf(format, args...)
+	}
+}
+
+// Path returns the path of the current file, which is its root
+// joined with its path below the root.
+func (w *Walker) Path() string {
+	return w.cur.path
+}
+
+// Root returns the root of the tree containing the current file.
+func (w *Walker) Root() string {
+	return w.cur.root
+}
+
+// Info returns the description of the current file. With Follow,
+// a symbolic link is described by its target.
+func (w *Walker) Info() os.FileInfo {
+	return w.cur.info
+}
+
+// Depth returns the depth of the current file below its root.
+func (w *Walker) Depth() int {
+	return w.cur.depth
+}
+
+// Err returns the first error encountered during the walk, if any.
+func (w *Walker) Err() error {
+	if len(w.errs) == 0 {
+		return nil
+	}
+	return w.errs[0]
+}
+
+// Errors returns all the errors encountered during the walk.
+func (w *Walker) Errors() []error {
+	return w.errs
+}
+
+// readDirNames reads the directory named by dirname and returns
+// a sorted list of directory entries.
+func readDirNames(dirname string) ([]string, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// A fileID identifies a file independently of the path by which it
+// was reached.
+type fileID struct {
+	dev, ino uint64
+	path     string // where device and inode numbers are unavailable
+}
+
+// realPath returns the absolute path of path with symbolic links
+// resolved, or path itself if that fails.
+func realPath(path string) string {
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+	return path
+}